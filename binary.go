@@ -0,0 +1,255 @@
+package lexer
+
+import "encoding/binary"
+
+// binaryVersion is the version of the binary encoding of token lists,
+// which is the first byte of the encoding, so that the format may be
+// changed without old encodings being misread.
+const binaryVersion = 2
+
+// The fields of a token other than its ID, value and index are only
+// encoded if they are set, as recorded by these flags.
+const (
+	binaryKind = 1 << iota
+	binaryName
+	binaryLine
+	binaryColumn
+	binaryEnd
+	binaryMode
+	binarySub
+	binaryRaw
+)
+
+// MarshalBinary encodes the list in a compact binary form, which may be
+// decoded with UnmarshalBinary to give an identical list, and which is
+// much smaller and quicker to decode than JSON for large lists. Each
+// token is encoded as its ID, the length of its value followed by the
+// value itself, and the difference between its index and that of the
+// previous token, all as varints, followed by any other fields which
+// are set, with any Raw text encoded in the same way as the value.
+// Names, modes and keywords are each encoded once, and referred to by
+// number thereafter. It never returns an error.
+func (t TokenList) MarshalBinary() ([]byte, error) {
+	e := binaryEncoder{strings: make(map[string]int)}
+	e.data = append(e.data, binaryVersion)
+	e.uvarint(uint64(len(t)))
+
+	index := 0
+	for _, token := range t {
+		e.varint(int64(token.ID))
+		e.uvarint(uint64(len(token.Value)))
+		e.data = append(e.data, token.Value...)
+		e.varint(int64(token.Index - index))
+		index = token.Index
+
+		flags := 0
+		for flag, set := range [...]bool{
+			token.Kind != 0, token.Name != "", token.Line != 0,
+			token.Column != 0, token.End != 0, token.Mode != "",
+			token.Sub != "", token.Raw != "",
+		} {
+			if set {
+				flags |= 1 << uint(flag)
+			}
+		}
+		e.uvarint(uint64(flags))
+
+		if flags&binaryKind != 0 {
+			e.varint(int64(token.Kind))
+		}
+		if flags&binaryName != 0 {
+			e.string(token.Name)
+		}
+		if flags&binaryLine != 0 {
+			e.varint(int64(token.Line))
+		}
+		if flags&binaryColumn != 0 {
+			e.varint(int64(token.Column))
+		}
+		if flags&binaryEnd != 0 {
+			e.varint(int64(token.End - token.Index))
+		}
+		if flags&binaryMode != 0 {
+			e.string(token.Mode)
+		}
+		if flags&binarySub != 0 {
+			e.string(token.Sub)
+		}
+		if flags&binaryRaw != 0 {
+			e.uvarint(uint64(len(token.Raw)))
+			e.data = append(e.data, token.Raw...)
+		}
+	}
+
+	return e.data, nil
+}
+
+// UnmarshalBinary replaces the contents of the list with the tokens
+// decoded from data encoded by MarshalBinary. A BinaryDataError is
+// returned if the data is not a valid encoding, in which case the list
+// is not modified.
+func (t *TokenList) UnmarshalBinary(data []byte) error {
+	d := binaryDecoder{data: data}
+	if len(data) == 0 || data[0] != binaryVersion {
+		return newBinaryDataError(0)
+	}
+	d.pos = 1
+
+	count := d.uvarint()
+	if count > uint64(len(data)) {
+		return newBinaryDataError(1)
+	}
+
+	list := make(TokenList, 0, count)
+	index := 0
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		var token Token
+		token.ID = int(d.varint())
+		token.Value = string(d.bytes(d.uvarint()))
+		token.Index = index + int(d.varint())
+		index = token.Index
+
+		flags := d.uvarint()
+		if flags&binaryKind != 0 {
+			token.Kind = Kind(d.varint())
+		}
+		if flags&binaryName != 0 {
+			token.Name = d.string()
+		}
+		if flags&binaryLine != 0 {
+			token.Line = int(d.varint())
+		}
+		if flags&binaryColumn != 0 {
+			token.Column = int(d.varint())
+		}
+		if flags&binaryEnd != 0 {
+			token.End = token.Index + int(d.varint())
+		}
+		if flags&binaryMode != 0 {
+			token.Mode = d.string()
+		}
+		if flags&binarySub != 0 {
+			token.Sub = d.string()
+		}
+		if flags&binaryRaw != 0 {
+			token.Raw = string(d.bytes(d.uvarint()))
+		}
+		list = append(list, token)
+	}
+
+	if d.err != nil {
+		return d.err
+	}
+	if d.pos != len(data) {
+		return newBinaryDataError(d.pos)
+	}
+
+	*t = list
+	return nil
+}
+
+// binaryEncoder accumulates the binary encoding of a token list. Each
+// distinct string other than a value is numbered in the order in which
+// it is first encoded.
+type binaryEncoder struct {
+	data    []byte
+	strings map[string]int
+}
+
+// uvarint appends an unsigned varint.
+func (e *binaryEncoder) uvarint(n uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.data = append(e.data, buf[:binary.PutUvarint(buf[:], n)]...)
+}
+
+// varint appends a signed varint.
+func (e *binaryEncoder) varint(n int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.data = append(e.data, buf[:binary.PutVarint(buf[:], n)]...)
+}
+
+// string appends the number of a string which has been encoded before,
+// or otherwise the next number, followed by the length of the string
+// and the string itself.
+func (e *binaryEncoder) string(s string) {
+	if n, ok := e.strings[s]; ok {
+		e.uvarint(uint64(n))
+		return
+	}
+	n := len(e.strings)
+	e.strings[s] = n
+	e.uvarint(uint64(n))
+	e.uvarint(uint64(len(s)))
+	e.data = append(e.data, s...)
+}
+
+// binaryDecoder decodes the binary encoding of a token list, recording
+// the first error it encounters, after which it decodes nothing more.
+type binaryDecoder struct {
+	data    []byte
+	pos     int
+	strings []string
+	err     Error
+}
+
+// uvarint decodes an unsigned varint.
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.data[d.pos:])
+	if size <= 0 {
+		d.err = newBinaryDataError(d.pos)
+		return 0
+	}
+	d.pos += size
+	return n
+}
+
+// varint decodes a signed varint.
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.data[d.pos:])
+	if size <= 0 {
+		d.err = newBinaryDataError(d.pos)
+		return 0
+	}
+	d.pos += size
+	return n
+}
+
+// bytes decodes the next n bytes.
+func (d *binaryDecoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		d.err = newBinaryDataError(d.pos)
+		return nil
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b
+}
+
+// string decodes a string encoded by binaryEncoder.string.
+func (d *binaryDecoder) string() string {
+	start := d.pos
+	n := d.uvarint()
+	switch {
+	case d.err != nil:
+		return ""
+	case n < uint64(len(d.strings)):
+		return d.strings[n]
+	case n > uint64(len(d.strings)):
+		d.err = newBinaryDataError(start)
+		return ""
+	}
+	s := string(d.bytes(d.uvarint()))
+	if d.err == nil {
+		d.strings = append(d.strings, s)
+	}
+	return s
+}
@@ -1,29 +1,363 @@
 package lexer
 
 import (
-	"unicode"
+	"bytes"
+	"context"
+	"io"
+	"unicode/utf8"
 )
 
 // indexedBuffer represents a byte buffer with a stored
 // "current byte" index. The lexer will work by reading
-// the entire contents of an io.Reader into this buffer,
-// and then attempt to successively match its contents
-// with regular expressions representing lexeme patterns.
-// The index will represent how much of the input we have
-// successfully translated into tokens.
+// the contents of an io.Reader into this buffer, and then
+// attempt to successively match its contents with regular
+// expressions representing lexeme patterns. The index will
+// represent how much of the input we have successfully
+// translated into tokens, and the rune count, line and column
+// will represent the same position in terms of runes and lines.
+//
+// Usually the entire contents of the io.Reader will be read
+// into the buffer at once, but the buffer may instead act as
+// a sliding window over the input, in which case the reader
+// will be non-nil until it is exhausted, and bytes which have
+// already been translated into tokens will be discarded each
+// time more input is read. For a modal lexer, the buffer also
+// holds the stack of modes, the last of which is current.
 type indexedBuffer struct {
-	buffer []byte
-	index  int
+	buffer    []byte
+	index     int
+	discarded int
+	runes     int
+	line      int
+	column    int
+	afterCR   bool
+	cfg       *config
+	reader    io.Reader
+	chunkSize int
+	finished  bool
+	pinned    int
+	isPinned  bool
+	modes     []string
+	bomDone   bool
+	interned  map[string]string
+	noDiscard bool
+	boundary  int
+	scanned   int
+	unbounded bool
+	indent    int
+	indented  bool
+	leading   bool
+	width     int
+	levels    []int
+	pending   []Token
+	stopped   bool
+	ctx       context.Context
+	groups    []string
 }
 
-// endOfInput checks if we've reached the end of the buffer.
+// newIndexedBuffer creates a new buffer positioned at the
+// start of the provided input. The lexer configuration
+// determines how the buffer reports its position.
+func newIndexedBuffer(input []byte, cfg *config) *indexedBuffer {
+	return &indexedBuffer{
+		buffer: input,
+		line:   1,
+		column: 1,
+		cfg:    cfg,
+	}
+}
+
+// newWindowedBuffer creates a new buffer which reads from the
+// provided reader in chunks of chunkSize bytes as more input is
+// needed.
+func newWindowedBuffer(reader io.Reader, chunkSize int,
+	cfg *config) *indexedBuffer {
+	b := newIndexedBuffer(nil, cfg)
+	b.reader = reader
+	b.chunkSize = chunkSize
+	return b
+}
+
+// complete checks if all the input has been read into the buffer.
+func (b *indexedBuffer) complete() bool {
+	return b.reader == nil
+}
+
+// discardable returns the number of bytes at the start of the
+// buffer which may be discarded, which is all the bytes before the
+// index, unless the buffer is pinned at an earlier position, or none
+// if the buffer doesn't own its bytes.
+func (b *indexedBuffer) discardable() int {
+	if b.noDiscard {
+		return 0
+	}
+	if b.isPinned && b.pinned < b.index {
+		return b.pinned
+	}
+	return b.index
+}
+
+// pin prevents the part of the buffer from the current index
+// onwards from being discarded until unpin is called.
+func (b *indexedBuffer) pin() {
+	b.pinned = b.index
+	b.isPinned = true
+}
+
+// unpin allows the part of the buffer pinned by pin to be discarded,
+// and returns the part of the buffer from the pinned position to the
+// current index.
+func (b *indexedBuffer) unpin() []byte {
+	b.isPinned = false
+	return b.buffer[b.pinned:b.index]
+}
+
+// fill discards the part of the buffer before the index, or before
+// the pinned position if the buffer is pinned, and reads at least
+// one more chunk of input into the buffer, unless the input has
+// been completely read.
+func (b *indexedBuffer) fill() Error {
+	if b.complete() {
+		return nil
+	}
+
+	if discard := b.discardable(); discard > 0 {
+		n := copy(b.buffer, b.buffer[discard:])
+		b.buffer = b.buffer[:n]
+		b.discarded += discard
+		b.index -= discard
+		if b.isPinned {
+			b.pinned -= discard
+		}
+	}
+
+	if cap(b.buffer)-len(b.buffer) < b.chunkSize {
+		grown := make([]byte, len(b.buffer), 2*len(b.buffer)+b.chunkSize)
+		copy(grown, b.buffer)
+		b.buffer = grown
+	}
+
+	n, err := b.reader.Read(b.buffer[len(b.buffer):cap(b.buffer)])
+	b.buffer = b.buffer[:len(b.buffer)+n]
+	if err == io.EOF {
+		b.reader = nil
+	} else if err != nil {
+		return readError(err, b.discarded+len(b.buffer))
+	}
+
+	return nil
+}
+
+// endOfInput checks if we've reached the end of the input.
 func (b *indexedBuffer) endOfInput() bool {
-	return b.index >= len(b.buffer)
+	return b.index >= len(b.buffer) && b.complete()
 }
 
-// advance advances the index by n bytes.
+// advance advances the index by n bytes, updating the line
+// and column to account for the runes consumed. If newlines
+// are being normalized, a carriage return, alone or followed
+// by a newline character, also ends a line. If indentation
+// is being measured, the width of the spaces and tabs at the
+// start of the current line is kept, with each tab reaching
+// the next multiple of 8.
 func (b *indexedBuffer) advance(n int) {
-	b.index += n
+	end := b.index + n
+	normalize := b.cfg.normalizeNewlines
+	for b.index < end {
+		r, size := utf8.DecodeRune(b.buffer[b.index:end])
+		switch {
+		case r == '\n' && normalize && b.afterCR:
+		case r == '\n' || (r == '\r' && normalize):
+			b.line++
+			b.column = 1
+			b.indent, b.indented = 0, false
+		default:
+			b.column++
+			if b.cfg.indentation && !b.indented {
+				switch r {
+				case ' ':
+					b.indent++
+				case '\t':
+					b.indent += 8 - b.indent%8
+				default:
+					b.indented = true
+				}
+			}
+		}
+		b.afterCR = r == '\r'
+		b.index += size
+		b.runes++
+	}
+}
+
+// carriageReturn returns the length in bytes of the newline
+// sequence at the current index if it starts with a carriage
+// return, which is 2 if the carriage return is followed by a
+// newline character, or 1 if it is not. Otherwise, it returns
+// 0. This should not be called if we're at the end of the
+// buffer.
+func (b *indexedBuffer) carriageReturn() (int, Error) {
+	if b.current() != '\r' {
+		return 0, nil
+	}
+
+	for b.index+1 >= len(b.buffer) && !b.complete() {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	if b.index+1 < len(b.buffer) && b.buffer[b.index+1] == '\n' {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// fillRune reads more input into the buffer, if necessary, until the
+// buffer holds the whole of the rune at the current index, or until
+// the input has been completely read.
+func (b *indexedBuffer) fillRune() Error {
+	for !b.complete() && !utf8.FullRune(b.next()) {
+		if err := b.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillRunes reads more input into the buffer, if necessary, until the
+// buffer holds at least n whole runes from the current index, or until
+// the input has been completely read.
+func (b *indexedBuffer) fillRunes(n int) Error {
+	for !b.complete() {
+		next, count := b.next(), 0
+		for count < n && utf8.FullRune(next) {
+			_, size := utf8.DecodeRune(next)
+			next = next[size:]
+			count++
+		}
+		if count == n {
+			return nil
+		}
+		if err := b.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipBOM advances the index past a UTF-8 byte order mark at the
+// start of the input, if there is one, reading more input as
+// necessary. The byte order mark counts towards the index and the
+// rune count, so that later positions are those in the original
+// input, but not towards the column, since it is not visible. It has
+// no effect once it has been called, or anywhere else in the input.
+func (b *indexedBuffer) skipBOM() Error {
+	if b.bomDone {
+		return nil
+	}
+	b.bomDone = true
+
+	for len(b.buffer)-b.index < len(byteOrderMark) && !b.complete() {
+		if err := b.fill(); err != nil {
+			return err
+		}
+	}
+
+	if b.offset() == 0 && bytes.HasPrefix(b.next(), byteOrderMark) {
+		b.index += len(byteOrderMark)
+		b.runes++
+	}
+	return nil
+}
+
+// boundaryLength returns the length in bytes of the input from the
+// current index up to the next rune for which isBoundary returns true,
+// or the length of the rune at the current index if it is one, so
+// that a boundary rune is always on its own. If there is no boundary
+// rune before the end of the input, it returns -1. More input is read
+// as necessary. The position of the boundary rune found is kept, so
+// that the input before it is only searched once. This should not be
+// called if we're at the end of the input.
+func (b *indexedBuffer) boundaryLength(isBoundary func(rune) bool) (int,
+	Error) {
+	if err := b.fillRune(); err != nil {
+		return 0, err
+	}
+	r, size := utf8.DecodeRune(b.next())
+	if isBoundary(r) {
+		return size, nil
+	}
+
+	at := b.discarded + b.index
+	if b.boundary > at {
+		return b.boundary - at, nil
+	}
+	if b.unbounded {
+		return -1, nil
+	}
+
+	pos := at + size
+	if b.scanned > pos {
+		pos = b.scanned
+	}
+	for {
+		for !b.complete() && !utf8.FullRune(b.buffer[pos-b.discarded:]) {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		if pos-b.discarded >= len(b.buffer) {
+			b.unbounded = true
+			return -1, nil
+		}
+
+		r, size := utf8.DecodeRune(b.buffer[pos-b.discarded:])
+		if isBoundary(r) {
+			b.boundary = pos
+			return pos - b.discarded - b.index, nil
+		}
+		pos += size
+		b.scanned = pos
+	}
+}
+
+// byteOrderMark is the UTF-8 encoding of the byte order mark.
+var byteOrderMark = []byte{0xEF, 0xBB, 0xBF}
+
+// skipRune advances the index past the rune at the current index,
+// reading more input if only part of the rune is in the buffer. This
+// should not be called if we're at the end of the input.
+func (b *indexedBuffer) skipRune() Error {
+	if err := b.fillRune(); err != nil {
+		return err
+	}
+	_, size := utf8.DecodeRune(b.next())
+	b.advance(size)
+	return nil
+}
+
+// hasPrefix checks if the input from the current index starts with
+// the provided prefix, reading more input if the buffer is too short
+// to tell.
+func (b *indexedBuffer) hasPrefix(prefix string) (bool, Error) {
+	for len(b.buffer)-b.index < len(prefix) && !b.complete() {
+		if err := b.fill(); err != nil {
+			return false, err
+		}
+	}
+	next := b.next()
+	return len(next) >= len(prefix) &&
+		string(next[:len(prefix)]) == prefix, nil
+}
+
+// offset returns the current position in the input, in either
+// bytes or runes depending on how the buffer was created.
+func (b *indexedBuffer) offset() int {
+	if b.cfg.runeIndex {
+		return b.runes
+	}
+	return b.discarded + b.index
 }
 
 // next returns a slice of the buffer fron the index through
@@ -39,20 +373,125 @@ func (b *indexedBuffer) current() byte {
 }
 
 // substring returns, in string format, a slice of the buffer
-// of n bytes starting from (and including) the current index.
+// of n bytes starting from (and including) the current index. If
+// values are being interned, the same string is returned each time
+// the same bytes are found, rather than a new copy, and if values
+// are not wanted, the empty string is returned.
 func (b *indexedBuffer) substring(n int) string {
-	return string(b.buffer[b.index : b.index+n])
+	value := b.buffer[b.index : b.index+n]
+	if b.cfg.withoutValues {
+		return ""
+	} else if !b.cfg.interning {
+		return string(value)
+	}
+
+	if interned, ok := b.interned[string(value)]; ok {
+		return interned
+	}
+	if b.interned == nil {
+		b.interned = make(map[string]string)
+	}
+	interned := string(value)
+	b.interned[interned] = interned
+	return interned
+}
+
+// runeReader returns a reader which reads runes from the buffer
+// starting at the current index, reading more input into the
+// buffer as necessary.
+func (b *indexedBuffer) runeReader() *bufferRuneReader {
+	return &bufferRuneReader{b, 0, nil}
 }
 
-// skipWhitespace advances the current index past any whitespace
-// characters. The newline character is treated as whitespace
-// if the provided argument is true.
-func (b *indexedBuffer) skipWhitespace(skipNewline bool) {
+// skipWhitespace advances the current index past any runes for
+// which isSpace returns true, reading more input as necessary.
+// The newline character is never skipped unless the provided
+// skipNewline argument is true. If newlines are being normalized,
+// a carriage return is treated as a newline character.
+func (b *indexedBuffer) skipWhitespace(skipNewline bool,
+	isSpace func(rune) bool) Error {
 	for !b.endOfInput() {
-		r := b.buffer[b.index]
-		if (!skipNewline && r == '\n') || !unicode.IsSpace(rune(r)) {
+		if !utf8.FullRune(b.next()) && !b.complete() {
+			if err := b.fill(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Most whitespace is ASCII, so avoid decoding a rune unless
+		// the current byte is the start of a multi-byte one.
+
+		r, size := rune(b.buffer[b.index]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRune(b.next())
+		}
+		if r == '\r' && b.cfg.normalizeNewlines {
+			r = '\n'
+		}
+		if (!skipNewline && r == '\n') || !isSpace(r) {
 			break
 		}
-		b.index++
+		b.advance(size)
+	}
+
+	return nil
+}
+
+// bufferRuneReader implements io.RuneReader over an indexedBuffer,
+// reading more input into the buffer when it runs out of runes.
+// The buffer index is not advanced, so every rune read remains
+// available in the buffer. Any error encountered while reading
+// the input is stored, so that it may be distinguished from the end
+// of the input.
+type bufferRuneReader struct {
+	b   *indexedBuffer
+	pos int
+	err Error
+}
+
+// ReadRune reads the next rune from the buffer.
+func (r *bufferRuneReader) ReadRune() (rune, int, error) {
+	for !r.b.complete() && !utf8.FullRune(r.b.next()[r.pos:]) {
+		if err := r.b.fill(); err != nil {
+			r.err = err
+			return 0, 0, err
+		}
+	}
+
+	if r.pos >= len(r.b.next()) {
+		return 0, 0, io.EOF
+	}
+
+	c, size := utf8.DecodeRune(r.b.next()[r.pos:])
+	r.pos += size
+	return c, size, nil
+}
+
+// readError returns the lexer error corresponding to an error
+// encountered while reading input after read bytes had been read,
+// which is an InputError unless the error is already a lexer error,
+// such as InputTooLargeError.
+func readError(err error, read int) Error {
+	if lerr, ok := err.(Error); ok {
+		return lerr
+	}
+	return newInputError(err, read)
+}
+
+// limitedReader implements io.Reader over another reader, returning
+// an InputTooLargeError once more than limit bytes have been read.
+type limitedReader struct {
+	reader io.Reader
+	limit  int
+	read   int
+}
+
+// Read reads from the underlying reader.
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += n
+	if r.read > r.limit {
+		return n - (r.read - r.limit), newInputTooLargeError(r.limit)
 	}
+	return n, err
 }
@@ -0,0 +1,48 @@
+package lexer
+
+// Builder assembles the lexeme patterns of a lexer one at a time,
+// compiling each pattern as it is added, so that an invalid pattern is
+// reported at the point at which it was added, rather than when the
+// lexer is created. The zero value of Builder is ready to use, and
+// creates a lexer with the default options.
+type Builder struct {
+	lexemes []string
+	names   []string
+	options []Option
+}
+
+// NewBuilder creates a new builder for a lexer which will be created
+// with the provided options.
+func NewBuilder(options ...Option) *Builder {
+	return &Builder{options: options}
+}
+
+// Add adds a lexeme pattern, with the name to be given to tokens
+// which match it, after those already added, so that its ID is the
+// number of patterns added before it. If the pattern can't be
+// compiled, a PatternError is returned, and if it matches the empty
+// string, an EmptyMatchPatternError is returned. In either case the
+// pattern is not added, and the IDs of later patterns are unaffected.
+func (b *Builder) Add(name, pattern string) Error {
+	if err := checkPattern(len(b.lexemes), pattern); err != nil {
+		return err
+	}
+
+	b.lexemes = append(b.lexemes, pattern)
+	b.names = append(b.names, name)
+	return nil
+}
+
+// Len returns the number of lexeme patterns added to the builder.
+func (b *Builder) Len() int {
+	return len(b.lexemes)
+}
+
+// Build creates a new lexer with the patterns and names added to the
+// builder, in the same way as NewNamed. The builder may continue to be
+// used afterwards, and doesn't affect lexers already built.
+func (b *Builder) Build() (*Lexer, Error) {
+	lexemes := append([]string(nil), b.lexemes...)
+	names := append([]string(nil), b.names...)
+	return NewNamed(lexemes, names, b.options...)
+}
@@ -0,0 +1,79 @@
+package lexer_test
+
+import (
+	"errors"
+	"github.com/paulgriffiths/lexer"
+	"regexp/syntax"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	b := lexer.NewBuilder(lexer.WithCaseInsensitive())
+	if err := b.Add("keyword", "if"); err != nil {
+		t.Fatalf("couldn't add pattern: %v", err)
+	}
+	if err := b.Add("bad", "[[:alpha:]"); err == nil {
+		t.Fatalf("bad pattern unexpectedly added")
+	}
+	if err := b.Add("identifier", "[[:alpha:]]+"); err != nil {
+		t.Fatalf("couldn't add pattern: %v", err)
+	}
+
+	if b.Len() != 2 {
+		t.Errorf("got %d patterns, want 2", b.Len())
+	}
+
+	l, err := b.Build()
+	if err != nil {
+		t.Fatalf("couldn't build lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("IF x")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Name: "keyword", Value: "IF", Index: 0},
+		lexer.Token{ID: 1, Name: "identifier", Value: "x", Index: 3},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+	for i, token := range tokens {
+		if token.Name != want[i].Name {
+			t.Errorf("token %d, got name %q, want %q",
+				i+1, token.Name, want[i].Name)
+		}
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	var b lexer.Builder
+	if err := b.Add("a", "a"); err != nil {
+		t.Fatalf("couldn't add pattern: %v", err)
+	}
+
+	err := b.Add("b", "(b")
+	if perr, ok := err.(lexer.PatternError); !ok {
+		t.Errorf("got error %v, want PatternError", err)
+	} else if perr.Index != 1 || perr.Pattern != "(b" {
+		t.Errorf("got pattern %d %q, want 1 %q", perr.Index, perr.Pattern, "(b")
+	}
+
+	var serr *syntax.Error
+	if !errors.As(err, &serr) || serr.Code != syntax.ErrMissingParen {
+		t.Errorf("got error %v, want missing parenthesis", err)
+	}
+
+	err = b.Add("c", "c*")
+	if eerr, ok := err.(lexer.EmptyMatchPatternError); !ok {
+		t.Errorf("got error %v, want EmptyMatchPatternError", err)
+	} else if eerr.Index != 1 {
+		t.Errorf("got index %d, want 1", eerr.Index)
+	}
+
+	if _, err := b.Build(); err != nil {
+		t.Errorf("couldn't build lexer: %v", err)
+	}
+}
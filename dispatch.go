@@ -0,0 +1,190 @@
+package lexer
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// firstByteDispatch finds lexemes for a lexer created with the
+// WithFirstByteDispatch option by looking first at the byte at which
+// the lexeme must start. The bytes with which a match for each pattern
+// may start are found from the parsed pattern when the lexer is
+// created. When no pattern may start with the current byte, there is
+// no match, without running any expression at all, and when only one
+// may, that pattern is matched on its own, which is much quicker than
+// matching the combined expression with a group for every pattern.
+// Otherwise, the combined expression is matched as usual. A pattern
+// for which the first byte can't be narrowed down, such as one which
+// starts with ".", may start with any byte, and so is always tried.
+type firstByteDispatch struct {
+	candidates [256][]int
+	anchored   []*regexp.Regexp
+}
+
+// newFirstByteDispatch creates a dispatch table for the provided
+// patterns, including any skip patterns, which must already have been
+// checked to be valid. The flags are those for the combined expression
+// of the lexer, which also apply to each pattern.
+func newFirstByteDispatch(patterns []string, flags string,
+	firstMatch bool) *firstByteDispatch {
+	d := &firstByteDispatch{anchored: make([]*regexp.Regexp, len(patterns))}
+
+	parseFlags := syntax.Perl
+	if flags != "" {
+		parseFlags |= syntax.FoldCase
+	}
+	for id, pattern := range patterns {
+		// A pattern such as \b which matches the empty string at
+		// some positions may match at any byte.
+
+		var first [256]bool
+		parsed, err := syntax.Parse(pattern, parseFlags)
+		if err != nil || firstBytes(parsed, &first) {
+			for c := range first {
+				first[c] = true
+			}
+		}
+		for c, ok := range first {
+			if ok {
+				d.candidates[c] = append(d.candidates[c], id)
+			}
+		}
+
+		d.anchored[id] = regexp.MustCompile(flags + "^(?:" + pattern + ")")
+		if !firstMatch {
+			d.anchored[id].Longest()
+		}
+	}
+
+	return d
+}
+
+// firstBytes adds the bytes with which a match for the provided
+// expression may start to first, and reports whether the expression
+// may match the empty string, in which case a match for whatever
+// follows it may also start there. Empty width assertions are treated
+// as matching the empty string, which may add bytes which can't in
+// fact start a match, but never leaves out any which can.
+func firstBytes(re *syntax.Regexp, first *[256]bool) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return true
+		}
+		r := re.Rune[0]
+		addRuneBytes(first, r, r)
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				addRuneBytes(first, f, f)
+			}
+		}
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			addRuneBytes(first, re.Rune[i], re.Rune[i+1])
+		}
+		return false
+	case syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		for c := range first {
+			if c != '\n' || re.Op == syntax.OpAnyChar {
+				first[c] = true
+			}
+		}
+		return false
+	case syntax.OpCapture, syntax.OpPlus:
+		return firstBytes(re.Sub[0], first)
+	case syntax.OpStar, syntax.OpQuest:
+		firstBytes(re.Sub[0], first)
+		return true
+	case syntax.OpRepeat:
+		return firstBytes(re.Sub[0], first) || re.Min == 0
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !firstBytes(sub, first) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		empty := false
+		for _, sub := range re.Sub {
+			if firstBytes(sub, first) {
+				empty = true
+			}
+		}
+		return empty
+	case syntax.OpNoMatch:
+		return false
+	}
+	return true
+}
+
+// addRuneBytes adds the first bytes of the UTF-8 encodings of the runes
+// from lo to hi to first. The first byte of an encoding never decreases
+// as the rune increases, so those of the runes in between are those in
+// between. The regular expression engine matches each byte of invalid
+// UTF-8 as the replacement character, so if that is in the range, every
+// byte other than an ASCII one is added too, since any of them may be
+// the start of invalid UTF-8.
+func addRuneBytes(first *[256]bool, lo, hi rune) {
+	if lo <= utf8.RuneError && utf8.RuneError <= hi {
+		for c := utf8.RuneSelf; c < len(first); c++ {
+			first[c] = true
+		}
+	}
+
+	// Surrogate halves have no encoding of their own, so leave them
+	// out of the range.
+
+	if lo >= 0xD800 && lo <= 0xDFFF {
+		lo = 0xE000
+	}
+	if hi >= 0xD800 && hi <= 0xDFFF {
+		hi = 0xD7FF
+	}
+	if hi > utf8.MaxRune {
+		hi = utf8.MaxRune
+	}
+	if lo > hi {
+		return
+	}
+
+	var buf [utf8.UTFMax]byte
+	utf8.EncodeRune(buf[:], lo)
+	from := buf[0]
+	utf8.EncodeRune(buf[:], hi)
+	for c := int(from); c <= int(buf[0]); c++ {
+		first[c] = true
+	}
+}
+
+// match finds the pattern which matches at the current index of the
+// buffer in the same way as matchRegexp, if at most one pattern may
+// start with the byte there, and otherwise reports that it hasn't,
+// so that the combined expression is matched instead.
+func (d *firstByteDispatch) match(b *indexedBuffer) (int, int, bool, Error) {
+	for b.index >= len(b.buffer) && !b.complete() {
+		if err := b.fill(); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	if b.index >= len(b.buffer) {
+		return 0, 0, false, nil
+	}
+
+	switch candidates := d.candidates[b.current()]; len(candidates) {
+	case 0:
+		return 0, -1, true, nil
+	case 1:
+		loc, err := findIndex(b, d.anchored[candidates[0]])
+		if err != nil {
+			return 0, 0, false, err
+		} else if loc == nil {
+			return 0, -1, true, nil
+		}
+		return candidates[0], loc[1], true, nil
+	}
+	return 0, 0, false, nil
+}
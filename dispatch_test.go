@@ -0,0 +1,98 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"strings"
+	"testing"
+)
+
+func TestFirstByteDispatch(t *testing.T) {
+	patternSets := [][]string{
+		{"[ACGT]+", ">[^\n]*", "\n"},
+		{"if", "[[:alpha:]]+", "[[:digit:]]+", "=", "==", `"[^"]*"`},
+		{`(?i)n+`, `x?y`, `(ab)*c`, `[é-ü]+`, `a{0,2}b`, `\bq`, "z|w+"},
+		{"[[:alpha:]]+", ".", `\p{Greek}+`, "[\x00-\x7f]"},
+	}
+	inputs := []string{
+		">seq one\nACGTTGCA\nGGAT\n",
+		"if x == 12 = \"a b\" iff",
+		"NNn y xy abababc c b aab ééü q z www",
+		"abc ? αβγ $\xff\xfe ü",
+		"ACGU\n",
+	}
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithFirstMatch()},
+		{lexer.WithCaseInsensitive()},
+		{lexer.WithSkipPatterns("#[^\n]*")},
+		{lexer.WithBufferSize(3)},
+	}
+
+	for p, patterns := range patternSets {
+		for n, options := range optionSets {
+			plain, err := lexer.New(patterns, options...)
+			if err != nil {
+				t.Fatalf("pattern set %d, option set %d, couldn't create "+
+					"lexer: %v", p+1, n+1, err)
+			}
+			dispatched, err := lexer.New(patterns, append(options,
+				lexer.WithFirstByteDispatch())...)
+			if err != nil {
+				t.Fatalf("pattern set %d, option set %d, couldn't create "+
+					"dispatching lexer: %v", p+1, n+1, err)
+			}
+
+			for i, input := range inputs {
+				// The context of a MatchError depends on how much input
+				// has been read, so only its position is compared.
+
+				want, werr := plain.LexString(input)
+				tokens, err := dispatched.LexString(input)
+				merr, _ := err.(lexer.MatchError)
+				wmerr, _ := werr.(lexer.MatchError)
+				if (err == nil) != (werr == nil) || merr.Index != wmerr.Index {
+					t.Errorf("pattern set %d, option set %d, case %d, got "+
+						"error %v, want %v", p+1, n+1, i+1, err, werr)
+				}
+				if diff := tokens.Diff(want); diff != "" {
+					t.Errorf("pattern set %d, option set %d, case %d, got "+
+						"unexpected tokens:\n%s", p+1, n+1, i+1, diff)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkFirstByteDispatch(b *testing.B, options ...lexer.Option) {
+	l, err := lexer.New([]string{"[ACGT]+", ">[^\n]*", "\n", "N+"},
+		options...)
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	var input strings.Builder
+	for i := 0; i < 2000; i++ {
+		if i%20 == 0 {
+			input.WriteString(">sequence\n")
+		}
+		input.WriteString(strings.Repeat("GATTACA", 8) + "NN" +
+			strings.Repeat("TGCA", 4) + "\n")
+	}
+	data := []byte(input.String())
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := l.LexBytes(data); err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+	}
+}
+
+func BenchmarkAlphabetPlain(b *testing.B) {
+	benchmarkFirstByteDispatch(b)
+}
+
+func BenchmarkAlphabetDispatched(b *testing.B) {
+	benchmarkFirstByteDispatch(b, lexer.WithFirstByteDispatch())
+}
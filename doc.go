@@ -10,10 +10,12 @@ user to identify which kind of token it is.
 
 The regular expressions passed as strings will be compiled by the normal
 Go regexp package, and can contain any regular expression that that
-package considers valid. One caution is that no regular expression
-passed should contained a *named* capturing group (which would never be
-helpful in any case, since the result of the regexp match is never seen,
-only the generated tokens).
+package considers valid, including those which contain capturing
+groups, named or otherwise. The input is treated as UTF-8 text, so
+patterns may use Unicode character classes such as \p{L} or \p{Greek},
+whitespace may be any Unicode space, and the value of each token is made
+up of whole runes. Positions are byte offsets into the input, unless the
+WithRuneIndex option is used, but columns are always counted in runes.
 
 In addition, since the strings will be passed verbatim to the regexp
 package, any characters in the pattern which may have special meaning
@@ -21,7 +23,9 @@ to the regular expression engine should be escaped. For example, if
 you want to match a literal left parenthesis, the pattern should be
 "\(", or "\\(" in source code, since the left parenthesis would otherwise
 be treated as the start of a capturing group by the regular expression
-engine.
+engine. Literal does this escaping, so Literal("(") is the same pattern,
+and NewLiterals creates a lexer in which every pattern is a literal
+string.
 
 Whitespace may be used to separate tokens, but is otherwise ignored by
 the lexical analyzer. The newline character is treated as whitespace and
@@ -30,5 +34,17 @@ patterns passed to the lexical analyzer at creation time, in which case
 each newline character will be returned as a separate token (unless
 another pattern embeds a newline character, such as may occur with
 multi-line comments in source code.)
+
+The default behavior described above may be changed by passing options
+to New, such as WithSkipPatterns to quietly consume comments, or
+WithWhitespace to change which characters are treated as whitespace.
+Calling New without any options, as in the example, gives the default
+behavior.
+
+Languages with distinct lexical contexts, such as templates in which
+text outside of delimiters is lexed differently from the expressions
+inside them, may be lexed with a modal lexer created by NewModal. Each
+mode has its own patterns, and matching a pattern may Push, Pop or Begin
+a mode, changing which patterns are used to identify the next token.
 */
 package lexer
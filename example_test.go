@@ -38,3 +38,34 @@ func Example() {
 	// Word        : "rats"  - found at index 19
 	// Punctuation : "."     - found at index 23
 }
+
+func ExampleWithFirstMatch() {
+	patterns := []string{"=", "==", "[[:alpha:]]+"}
+
+	for _, options := range [][]lexer.Option{
+		nil,
+		[]lexer.Option{lexer.WithFirstMatch()},
+	} {
+		lex, err := lexer.New(patterns, options...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't create lexer: %v", err)
+			os.Exit(1)
+		}
+
+		tokens, err := lex.LexString("a == b")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't lex input: %v", err)
+			os.Exit(1)
+		}
+
+		values := []string{}
+		for _, t := range tokens {
+			values = append(values, fmt.Sprintf("%q", t.Value))
+		}
+		fmt.Println(strings.Join(values, " "))
+	}
+
+	// Output:
+	// "a" "==" "b"
+	// "a" "=" "=" "b"
+}
@@ -0,0 +1,88 @@
+package lexer
+
+import (
+	"strings"
+	"unicode"
+)
+
+// keywordPatternID is the ID of the lexeme pattern which matches the
+// keywords of a lexer created with NewKeywords, and which keywords
+// matched by the identifier pattern are given.
+const keywordPatternID = 1
+
+// NewKeywords creates a new lexer in the same way as New, from a
+// lexeme pattern for identifiers and a set of keywords, which are
+// literal strings rather than patterns. The lexer identifies lexemes
+// matching the identifier pattern with ID 0, unless the lexeme is one
+// of the keywords, in which case it has ID 1, and its Sub is the
+// keyword, so that a parser doesn't need to compare the value with
+// each keyword to tell which it is. Keywords which the identifier
+// pattern doesn't match are also found, with ID 1. If the lexer is
+// created with the WithCaseInsensitive option, keywords are found
+// regardless of case, and Sub is the keyword as provided, rather
+// than as found. Further lexeme patterns may be added with With.
+func NewKeywords(ident string, keywords []string,
+	options ...Option) (*Lexer, Error) {
+	if len(keywords) == 0 {
+		return New([]string{ident}, options...)
+	}
+
+	quoted := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		quoted[i] = Literal(keyword)
+	}
+
+	lexer, err := New([]string{ident, strings.Join(quoted, "|")},
+		options...)
+	if err != nil {
+		return nil, err
+	}
+
+	lexer.keywords = make(map[string]string, len(keywords))
+	for _, keyword := range keywords {
+		key := lexer.foldKeyword(keyword)
+		if _, ok := lexer.keywords[key]; !ok {
+			lexer.keywords[key] = keyword
+		}
+	}
+
+	return lexer, nil
+}
+
+// keyword returns the keyword which a token matched by the lexeme
+// pattern with the provided id is, if the lexer was created with
+// NewKeywords and the pattern is either the identifier pattern or the
+// keywords pattern.
+func (l *Lexer) keyword(id int, value string) (string, bool) {
+	if l.keywords == nil || id > keywordPatternID {
+		return "", false
+	}
+	keyword, ok := l.keywords[l.foldKeyword(value)]
+	return keyword, ok
+}
+
+// foldKeyword returns the form of a keyword, or a value which may be
+// one, used to look it up. If the lexer is case insensitive, each rune
+// is folded in the same way as by the regular expression engine, so
+// that every value which the keywords pattern matches is found, such
+// as "ΣΑΣ" for the keyword "σας", which strings.ToLower would turn
+// into "σασ".
+func (l *Lexer) foldKeyword(keyword string) string {
+	if l.caseInsensitive {
+		return strings.Map(foldRune, keyword)
+	}
+	return keyword
+}
+
+// foldRune returns the smallest of the runes which are equivalent to
+// the provided rune under simple case folding, which the regular
+// expression engine uses to match regardless of case.
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded
+}
@@ -0,0 +1,110 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		want    lexer.TokenList
+		subs    []string
+	}{
+		{
+			nil,
+			"if iffy else += x",
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "if", Index: 0},
+				lexer.Token{ID: 0, Value: "iffy", Index: 3},
+				lexer.Token{ID: 1, Value: "else", Index: 8},
+				lexer.Token{ID: 1, Value: "+=", Index: 13},
+				lexer.Token{ID: 0, Value: "x", Index: 16},
+			},
+			[]string{"if", "", "else", "+=", ""},
+		},
+		{
+			nil,
+			"IF If",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "IF", Index: 0},
+				lexer.Token{ID: 0, Value: "If", Index: 3},
+			},
+			[]string{"", ""},
+		},
+		{
+			[]lexer.Option{lexer.WithCaseInsensitive()},
+			"IF Else x",
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "IF", Index: 0},
+				lexer.Token{ID: 1, Value: "Else", Index: 3},
+				lexer.Token{ID: 0, Value: "x", Index: 8},
+			},
+			[]string{"if", "else", ""},
+		},
+		{
+			[]lexer.Option{lexer.WithCaseInsensitive()},
+			"ΣΑΣ Σας σασ ПОКА",
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "ΣΑΣ", Index: 0},
+				lexer.Token{ID: 1, Value: "Σας", Index: 7},
+				lexer.Token{ID: 1, Value: "σασ", Index: 14},
+				lexer.Token{ID: 1, Value: "ПОКА", Index: 21},
+			},
+			[]string{"σας", "σας", "σας", "пока"},
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.NewKeywords(`\p{L}+`,
+			[]string{"if", "else", "+=", "σας", "пока"}, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Errorf("case %d, couldn't lex input: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(tc.want) {
+			t.Errorf("case %d, got %v, want %v", n+1, tokens, tc.want)
+			continue
+		}
+		for i, token := range tokens {
+			if token.Sub != tc.subs[i] {
+				t.Errorf("case %d, token %d, got sub %q, want %q",
+					n+1, i+1, token.Sub, tc.subs[i])
+			}
+		}
+	}
+}
+
+func TestKeywordsWith(t *testing.T) {
+	l, err := lexer.NewKeywords("[[:alpha:]]+", []string{"return"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	l, err = l.With("[[:digit:]]+")
+	if err != nil {
+		t.Fatalf("couldn't add pattern: %v", err)
+	}
+
+	tokens, err := l.LexString("return 42")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "return", Index: 0},
+		lexer.Token{ID: 2, Value: "42", Index: 7},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+	if tokens[0].Sub != "return" {
+		t.Errorf("got sub %q, want %q", tokens[0].Sub, "return")
+	}
+}
@@ -0,0 +1,62 @@
+package lexer
+
+// Kind identifies a kind of token independently of the position of the
+// lexeme pattern which matches it, so that the patterns of a lexer may
+// be reordered without changing the meaning of the tokens it returns.
+type Kind int
+
+// KindPattern is a lexeme pattern, along with the kind of the tokens
+// which it matches.
+type KindPattern struct {
+	Kind    Kind
+	Pattern string
+}
+
+// NewKinds creates a new lexer in the same way as New, from lexeme
+// patterns which each have a kind, so that the Kind of each token the
+// lexer returns is the kind of the pattern used to identify it. The ID
+// of each token is still the index of that pattern, and where more than
+// one pattern matches, the order of the patterns is significant in the
+// same way as for New. Any number of patterns may have the same kind.
+// Patterns later added with With have the kind which is their ID.
+func NewKinds(patterns []KindPattern, options ...Option) (*Lexer,
+	Error) {
+	lexemes := make([]string, len(patterns))
+	kinds := make([]Kind, len(patterns))
+	for i, pattern := range patterns {
+		lexemes[i] = pattern.Pattern
+		kinds[i] = pattern.Kind
+	}
+
+	lexer, err := New(lexemes, options...)
+	if err != nil {
+		return nil, err
+	}
+	lexer.kinds = kinds
+
+	return lexer, nil
+}
+
+// PositionalKinds returns the provided lexeme patterns, each with the
+// kind which is its index, so that a lexer created from them with
+// NewKinds returns the same tokens as one created with New, but with
+// each Kind the same as the ID, as a step towards assigning kinds which
+// don't depend on the order of the patterns.
+func PositionalKinds(lexemes []string) []KindPattern {
+	patterns := make([]KindPattern, len(lexemes))
+	for i, lexeme := range lexemes {
+		patterns[i] = KindPattern{Kind(i), lexeme}
+	}
+	return patterns
+}
+
+// kind returns the kind of tokens with the provided ID, which is the
+// kind of the pattern with that ID, or the ID itself for patterns
+// without one, such as those added with With, and for EOF and the
+// other special IDs.
+func (l *Lexer) kind(id int) Kind {
+	if id >= 0 && id < len(l.kinds) {
+		return l.kinds[id]
+	}
+	return Kind(id)
+}
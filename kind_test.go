@@ -0,0 +1,107 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+const (
+	kindWord lexer.Kind = iota + 1
+	kindNumber
+	kindPlus
+)
+
+func TestKinds(t *testing.T) {
+	patterns := [][]lexer.KindPattern{
+		{
+			{kindWord, "[[:alpha:]]+"},
+			{kindNumber, "[[:digit:]]+"},
+			{kindPlus, `\+`},
+		},
+		{
+			{kindPlus, `\+`},
+			{kindNumber, "[[:digit:]]+"},
+			{kindWord, "[[:alpha:]]+"},
+		},
+	}
+
+	// The kind of each token is the same however the patterns are
+	// ordered, although its ID is not.
+
+	want := []lexer.Kind{kindWord, kindPlus, kindNumber, lexer.EOF}
+	for n, p := range patterns {
+		l, err := lexer.NewKinds(p, lexer.WithEOFToken())
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString("a + 1")
+		if err != nil {
+			t.Fatalf("case %d, couldn't lex input: %v", n+1, err)
+		}
+		if len(tokens) != len(want) {
+			t.Fatalf("case %d, got %d tokens, want %d", n+1, len(tokens),
+				len(want))
+		}
+		for i, token := range tokens {
+			if token.Kind != want[i] {
+				t.Errorf("case %d, token %d, got kind %d, want %d", n+1,
+					i, token.Kind, want[i])
+			}
+		}
+	}
+
+	// Patterns added with With have the kind which is their ID.
+
+	l, err := lexer.NewKinds(patterns[0])
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	l, err = l.With("-")
+	if err != nil {
+		t.Fatalf("couldn't add pattern: %v", err)
+	}
+	tokens, err := l.LexString("a-")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Kind != kindWord ||
+		tokens[1].Kind != lexer.Kind(3) {
+		t.Errorf("got tokens %v, want kinds %d and 3", tokens, kindWord)
+	}
+}
+
+func TestPositionalKinds(t *testing.T) {
+	lexemes := []string{"[[:alpha:]]+", "[[:digit:]]+"}
+	l, err := lexer.NewKinds(lexer.PositionalKinds(lexemes))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("a 1 b")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Kind != lexer.Kind(token.ID) {
+			t.Errorf("got kind %d for token %v, want %d", token.Kind,
+				token, token.ID)
+		}
+	}
+
+	// A lexer created with New leaves the kind unset.
+
+	l, err = lexer.New(lexemes)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	tokens, err = l.LexString("a 1")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+	for _, token := range tokens {
+		if token.Kind != 0 {
+			t.Errorf("got kind %d for token %v, want 0", token.Kind, token)
+		}
+	}
+}
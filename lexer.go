@@ -1,150 +1,1585 @@
 package lexer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
-// Lexer implements a general-purpose lexical analyzer.
+// Lexer implements a general-purpose lexical analyzer. A lexer holds
+// no state relating to any particular input, so a single lexer may
+// be used to lex any number of inputs, and its methods may safely be
+// called concurrently from multiple goroutines.
 type Lexer struct {
 	lexemes     []string
+	names       []string
 	regexps     *regexp.Regexp
+	anchored    []*regexp.Regexp
+	groups      []int
 	skipNewline bool
+	newline     int
+	literals    *literalNode
+	optimized   *optimizedMatcher
+	dispatch    *firstByteDispatch
+	prefixes    *lazyPrefixMatcher
+	keywords    map[string]string
+	kinds       []Kind
+	stats       *statsCounter
+	modes       map[string]*Lexer
+	actions     map[string][]Action
+	config
 }
 
 // New creates a new lexer from a slice of strings containing regular
 // expressions to match lexemes. Later, the Lex function will return
 // a list of tokens with an (id, value) pair. The id will be the index
 // in this slice of the pattern that was matched to identify that
-// lexeme, so the order is significant.
-func New(lexemes []string) (*Lexer, Error) {
+// lexeme, so the order is significant. The behavior of the lexer
+// may be changed from the default by providing any number of options.
+// At least one pattern must be provided, or a NoPatternsError is
+// returned. Patterns which match the empty string, including the
+// empty pattern itself, are rejected with an EmptyMatchPatternError,
+// since they would match without consuming any input.
+func New(lexemes []string, options ...Option) (*Lexer, Error) {
+	return newLexer(lexemes, newConfig(options))
+}
+
+// newLexer creates a new lexer in the same way as New, with an
+// already built configuration.
+func newLexer(lexemes []string, cfg config) (*Lexer, Error) {
+	if len(lexemes) == 0 {
+		return nil, newNoPatternsError()
+	}
+	if cfg.fallback && (cfg.fallbackID < 0 || cfg.fallbackID >= len(lexemes)) {
+		return nil, newFallbackIDError(cfg.fallbackID, len(lexemes))
+	}
+	if cfg.stop && (cfg.stopID < 0 || cfg.stopID >= len(lexemes)) {
+		return nil, newStopPatternError(cfg.stopID, len(lexemes))
+	}
+
 	skipNewline := true
+	newline := -1
 
 	// Build up a combined regular expression for all lexemes
-	// so that we may identify them in linear time.
+	// so that we may identify them in linear time. Any skip
+	// patterns are included after the lexemes, so that they
+	// participate in finding the longest match.
 
 	regexpString := ""
-	for i, lexeme := range lexemes {
+	groups := []int{}
+	group := 1
+	literals := []string{}
+	allLiteral := true
+	anchored := []*regexp.Regexp{}
+	flags := ""
+	if cfg.caseInsensitive {
+		flags = "(?i)"
+	}
+	for i, lexeme := range append(lexemes[:len(lexemes):len(lexemes)],
+		cfg.skipPatterns...) {
 
 		// We're going to ignore whitespace between tokens,
 		// including newline characters, unless the newline
 		// character is specified as one of the lexemes.
 
-		if lexeme == "\n" {
+		if lexeme == "\n" && i < len(lexemes) {
 			skipNewline = false
+			if newline == -1 {
+				newline = i
+			}
 		}
 		if i != 0 {
 			regexpString += "|"
 		}
 
-		// Each lexeme pattern will be a named capturing group
-		// in the combined regular expression. We will identify
-		// which lexeme pattern we have matched by identifying
-		// which capturing group was matched. We have to use
-		// named capturing groups here, because if any lexeme
-		// pattern contains a parenthesized expression then
-		// neither the number of subexpressions nor their ordering
-		// will match the slice of lexeme patterns provided to
-		// the lexer.
+		// Compile each lexeme pattern individually, both to ensure
+		// that it is valid on its own, and so cannot interfere with
+		// the structure of the combined regular expression, and to
+		// count the capturing groups it contains.
+
+		compiled, err := regexp.Compile(lexeme)
+		if err != nil {
+			return nil, newRegexError(err)
+		}
+
+		// A pattern which matches the empty string would produce
+		// tokens which consume no input, and so the lexer would
+		// never get any further.
+
+		if compiled.MatchString("") {
+			return nil, newEmptyMatchPatternError(i, lexeme)
+		}
+
+		// Keep an anchored form of each lexeme pattern, so that we
+		// can tell whether it matches at a position on its own. It
+		// prefers the longest match, so that matching the value of a
+		// token always matches the whole of it.
+
+		if i < len(lexemes) {
+			pattern := regexp.MustCompile(flags + "^(?:" + lexeme + ")")
+			pattern.Longest()
+			anchored = append(anchored, pattern)
+		}
+
+		// Each lexeme pattern will be a capturing group in the
+		// combined regular expression. We will identify which
+		// lexeme pattern we have matched by identifying which of
+		// these capturing groups was matched. Since lexeme patterns
+		// may contain capturing groups of their own, named or
+		// otherwise, we record the index of each of our groups
+		// rather than assuming the indices of the groups will match
+		// the indices of the lexeme patterns. The groups are named
+		// after the lexeme patterns only for the benefit of anyone
+		// reading the combined regular expression.
 
 		regexpString += fmt.Sprintf("(?P<%d>^%s)", i, lexeme)
+		groups = append(groups, group)
+		group += 1 + compiled.NumSubexp()
+
+		// Record whether the pattern matches only a literal string,
+		// and if it does, what it is.
+
+		literal, complete := compiled.LiteralPrefix()
+		if !complete {
+			allLiteral = false
+		}
+		literals = append(literals, literal)
 	}
 
+	// Anchor the combined expression as a whole, as well as each of
+	// its groups, so that the regular expression engine knows that a
+	// match may only start at the beginning of its input. Otherwise it
+	// may go on to try every later position in the input, which is
+	// wasted effort, since none of the groups can match there.
+
+	regexpString = "^(?:" + regexpString + ")"
+
+	// The case-insensitive flag applies to the whole combined
+	// expression, but does not affect the group names, or the
+	// anchors at the start of each group.
+
+	regexpString = flags + regexpString
+
 	compiledRegex, err := regexp.Compile(regexpString)
 	if err != nil {
 		return nil, newRegexError(err)
 	}
-	compiledRegex.Longest()
+	if !cfg.firstMatch {
+		compiledRegex.Longest()
+	}
 
-	lexer := Lexer{lexemes, compiledRegex, skipNewline}
+	// If every pattern is a literal string, we can match them much
+	// faster with a trie than with the combined expression, which is
+	// still compiled, since it validates the patterns as a whole. The
+	// case-insensitive flag isn't reflected in the literal strings, so
+	// we can't use them then.
+
+	var trie *literalNode
+	if allLiteral && !cfg.caseInsensitive && !cfg.recordGroups {
+		trie = newLiteralTrie(literals)
+	}
+
+	// Otherwise, if asked to, we can match with an expression built
+	// to be matched quickly rather than to identify the pattern.
+
+	var optimized *optimizedMatcher
+	if trie == nil && cfg.optimizedPattern && !cfg.recordGroups {
+		var oerr Error
+		optimized, oerr = newOptimizedMatcher(append(
+			lexemes[:len(lexemes):len(lexemes)], cfg.skipPatterns...),
+			flags, cfg.firstMatch)
+		if oerr != nil {
+			return nil, oerr
+		}
+	}
+
+	// If asked to, we can also avoid the combined expression at
+	// positions where at most one pattern may match.
+
+	var dispatch *firstByteDispatch
+	if trie == nil && cfg.firstByteDispatch && !cfg.recordGroups {
+		dispatch = newFirstByteDispatch(append(
+			lexemes[:len(lexemes):len(lexemes)], cfg.skipPatterns...),
+			flags, cfg.firstMatch)
+	}
+
+	lexer := Lexer{
+		lexemes:     lexemes,
+		regexps:     compiledRegex,
+		anchored:    anchored,
+		groups:      groups,
+		skipNewline: skipNewline,
+		newline:     newline,
+		literals:    trie,
+		optimized:   optimized,
+		dispatch:    dispatch,
+		prefixes:    &lazyPrefixMatcher{},
+		config:      cfg,
+	}
+	if cfg.stats {
+		lexer.stats = &statsCounter{matches: make(map[int]int)}
+	}
 	return &lexer, nil
 }
 
-// Lex lexically analyses the input and returns a list of tokens.
+// NewNamed creates a new lexer in the same way as New, but also
+// associates a human-readable name with each lexeme pattern, so
+// that the tokens it returns will carry the name of the pattern
+// that was matched to identify them. The names must be provided
+// in the same order as the patterns.
+func NewNamed(lexemes []string, names []string,
+	options ...Option) (*Lexer, Error) {
+	if len(names) != len(lexemes) {
+		return nil, newNamesError(len(lexemes), len(names))
+	}
+
+	lexer, err := New(lexemes, options...)
+	if err != nil {
+		return nil, err
+	}
+	lexer.names = names
+
+	return lexer, nil
+}
+
+// NewFromRegexps creates a new lexer in the same way as New, but from
+// already compiled regular expressions rather than strings, such as
+// expressions which are shared with other code. The combined
+// expression is built from the source of each expression, so flags
+// set with the Longest method of an expression are not preserved.
+// No name may be used by more than one capturing group, and no group
+// may be named with a decimal number, since such names are used for
+// the groups the lexer wraps around each expression, or a
+// GroupNameError is returned.
+func NewFromRegexps(res []*regexp.Regexp, options ...Option) (*Lexer,
+	Error) {
+	lexemes := make([]string, len(res))
+	names := make(map[string]bool)
+	for i, re := range res {
+		for _, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if _, err := strconv.Atoi(name); err == nil || names[name] {
+				return nil, newGroupNameError(i, name)
+			}
+			names[name] = true
+		}
+		lexemes[i] = re.String()
+	}
+
+	return New(lexemes, options...)
+}
+
+// NewLiterals creates a new lexer in the same way as New, but the
+// lexemes are literal strings rather than regular expressions, so that
+// characters such as ( and + match themselves, and need no escaping.
+func NewLiterals(literals []string, options ...Option) (*Lexer, Error) {
+	lexemes := make([]string, len(literals))
+	for i, literal := range literals {
+		lexemes[i] = Literal(literal)
+	}
+	return New(lexemes, options...)
+}
+
+// Literal returns a lexeme pattern which matches only the provided
+// string, with any characters which have a special meaning in a
+// regular expression escaped, so that Literal("a+b") matches "a+b",
+// rather than one or more "a" followed by "b". It may be combined
+// with other patterns, as in Literal("(") + "[[:digit:]]+".
+func Literal(s string) string {
+	return regexp.QuoteMeta(s)
+}
+
+// Candidates returns, in order, the IDs of every lexeme pattern which
+// matches the input at the provided byte index on its own, rather
+// than only the ID of the pattern which would win, such as to suggest
+// which kinds of token could appear at that index. Whitespace at the
+// index is not skipped. No IDs are returned if the index is outside
+// the input, or for a modal lexer.
+func (l *Lexer) Candidates(input []byte, at int) []int {
+	if at < 0 || at > len(input) {
+		return nil
+	}
+
+	var ids []int
+	for id, pattern := range l.anchored {
+		if pattern.Match(input[at:]) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Pattern returns the source of the combined regular expression the
+// lexer uses to match lexemes, for debugging. Each lexeme pattern, and
+// then each skip pattern, is an alternative in a capturing group named
+// after its index, and anchored to the start of the input, as in
+// ^(?:(?P<0>^if)|(?P<1>^[[:alpha:]]+)). A modal lexer has a combined
+// regular expression for each mode instead, so its Pattern is empty.
+func (l *Lexer) Pattern() string {
+	if l.modes != nil {
+		return ""
+	}
+	return l.regexps.String()
+}
+
+// With creates a new lexer with the same lexeme patterns, names and
+// options as the lexer, followed by the extra patterns, so that the
+// IDs of the original patterns are unchanged, and the IDs of the extra
+// patterns follow them in order. The extra patterns have no names. For
+// a modal lexer, the extra patterns are added to every mode, with no
+// actions. The new lexer is independent of the original, which is not
+// modified.
+func (l *Lexer) With(extra ...string) (*Lexer, Error) {
+	if l.modes != nil {
+		modes := make(map[string]*Lexer, len(l.modes))
+		for mode, modeLexer := range l.modes {
+			lexer, err := modeLexer.With(extra...)
+			if err != nil {
+				return nil, err
+			}
+			modes[mode] = lexer
+		}
+
+		lexer := *l
+		lexer.modes = modes
+		return &lexer, nil
+	}
+
+	lexemes := append(l.lexemes[:len(l.lexemes):len(l.lexemes)], extra...)
+	lexer, err := newLexer(lexemes, l.config)
+	if err != nil {
+		return nil, err
+	}
+	lexer.names = l.names
+	lexer.keywords = l.keywords
+	lexer.kinds = l.kinds
+
+	return lexer, nil
+}
+
+// Without creates a new lexer in the same way as With, but in which
+// the lexeme patterns with the provided IDs can never be matched, so
+// that input which one of them would have matched is identified by
+// another pattern, or not at all. The IDs of the other patterns are
+// unchanged. IDs which are not those of lexeme patterns are ignored.
+// Since the combined expression is compiled again without the
+// patterns, it is best to create a lexer with Without once for each
+// set of patterns required, rather than each time it is used. For a
+// modal lexer, the patterns with the IDs are removed from every mode.
+func (l *Lexer) Without(ids ...int) (*Lexer, Error) {
+	if l.modes != nil {
+		modes := make(map[string]*Lexer, len(l.modes))
+		for mode, modeLexer := range l.modes {
+			lexer, err := modeLexer.Without(ids...)
+			if err != nil {
+				return nil, err
+			}
+			modes[mode] = lexer
+		}
+
+		lexer := *l
+		lexer.modes = modes
+		return &lexer, nil
+	}
+
+	lexemes := append([]string(nil), l.lexemes...)
+	keywords := l.keywords
+	for _, id := range ids {
+		if id < 0 || id >= len(lexemes) {
+			continue
+		}
+		lexemes[id] = neverMatch
+		if id == keywordPatternID {
+			keywords = nil
+		}
+	}
+
+	lexer, err := newLexer(lexemes, l.config)
+	if err != nil {
+		return nil, err
+	}
+	lexer.names = l.names
+	lexer.keywords = keywords
+	lexer.kinds = l.kinds
+
+	return lexer, nil
+}
+
+// neverMatch is a pattern which matches no input, not even the empty
+// string, which takes the place of patterns removed with Without so
+// that the IDs of the other patterns are unchanged.
+const neverMatch = `[^\x00-\x{10FFFF}]`
+
+// Filename returns the name of the input provided with the WithFilename
+// option, or the empty string if none was provided.
+func (l *Lexer) Filename() string {
+	return l.filename
+}
+
+// Name returns the name associated with the lexeme pattern with the
+// provided id, or the empty string if the lexer was not created with
+// names or if there is no such pattern.
+func (l *Lexer) Name(id int) string {
+	if id < 0 || id >= len(l.names) {
+		return ""
+	}
+	return l.names[id]
+}
+
+// NewlineID returns the ID of the lexeme pattern which is the newline
+// character by itself, and true, if there is one, so that newline
+// tokens can be recognised without relying on the position of the
+// pattern. If more than one pattern is the newline character, the ID is
+// that of the first. False is returned if there is no such pattern,
+// and for a modal lexer, since each mode may have a different one.
+func (l *Lexer) NewlineID() (int, bool) {
+	if l.modes != nil || l.newline == -1 {
+		return 0, false
+	}
+	return l.newline, true
+}
+
+// Submatches returns the text matched by each capturing group in the
+// lexeme pattern used to identify the token, in order, or the empty
+// string for a group which did not match, such as to tell which
+// alternative of the pattern matched. Unless the lexer was created with
+// the WithGroups option, the groups are found by matching the pattern
+// against the text of the token again, so this costs nothing unless it
+// is called, but the input following the token is then not seen, so a
+// pattern whose groups depend on it, such as one using \b or $, may
+// report different groups than the match which identified the token.
+// Nil is returned if the pattern has no capturing groups, or if the
+// token was not identified by one of the lexer's lexeme patterns. For a
+// modal lexer, the pattern is that for the Mode of the token.
+func (l *Lexer) Submatches(token Token) []string {
+	if token.groups != nil {
+		return append([]string(nil), *token.groups...)
+	}
+	if l.modes != nil {
+		if modeLexer, ok := l.modes[token.Mode]; ok {
+			return modeLexer.Submatches(token)
+		}
+		return nil
+	}
+
+	if token.ID < 0 || token.ID >= len(l.anchored) {
+		return nil
+	}
+	pattern := l.anchored[token.ID]
+	if pattern.NumSubexp() == 0 {
+		return nil
+	}
+
+	text := token.text()
+	matches := pattern.FindStringSubmatch(text)
+	if matches == nil || len(matches[0]) != len(text) {
+		return nil
+	}
+	return matches[1:]
+}
+
+// Lex lexically analyses the input and returns a list of tokens. If
+// lexing stops with an error, the list returned with it contains every
+// token found before the error was encountered.
 func (l *Lexer) Lex(input io.Reader) (TokenList, Error) {
-	bytes, err := ioutil.ReadAll(input)
+	list := TokenList{}
+
+	err := l.lex(input, func(token Token) Error {
+		list = append(list, token)
+		return nil
+	}, nil)
+
+	return list, err
+}
+
+// LexInto lexically analyses the input in the same way as Lex, but
+// appends the tokens to dst and returns the extended list, so that the
+// storage of a list, such as one kept in a sync.Pool, may be reused
+// across calls rather than a new list being allocated each time. Pass
+// dst[:0] to replace its contents. The value of each token is still a
+// newly allocated string, or with the WithInterning option, one for
+// each distinct value in the input, so reusing the list saves only the
+// allocations of the list itself.
+func (l *Lexer) LexInto(input io.Reader, dst TokenList) (TokenList, Error) {
+	err := l.lex(input, func(token Token) Error {
+		dst = append(dst, token)
+		return nil
+	}, nil)
+
+	return dst, err
+}
+
+// LexContext lexically analyses the input in the same way as Lex,
+// but stops and returns a ContextError if the provided context is
+// cancelled or its deadline passes before lexing is complete. The
+// context is checked before lexing starts, before each attempt to
+// match the patterns, and after each token is found, so that input
+// which yields no tokens, such as whitespace or skip patterns, is
+// also stopped, and the tokens found before it was cancelled are
+// returned with the error.
+func (l *Lexer) LexContext(ctx context.Context, input io.Reader) (TokenList,
+	Error) {
+	list := TokenList{}
+	if err := ctx.Err(); err != nil {
+		return list, newContextError(err)
+	}
+
+	buffer, err := l.newBuffer(input)
 	if err != nil {
-		return nil, newInputError(err)
+		return list, err
+	}
+	buffer.ctx = ctx
+	done := ctx.Done()
+
+	err = l.lexBuffer(buffer, func(token Token) Error {
+		select {
+		case <-done:
+			return newContextError(ctx.Err())
+		default:
+		}
+		list = append(list, token)
+		return nil
+	}, nil)
+
+	return list, err
+}
+
+// LexFunc lexically analyses the input, calling fn for each token in
+// the order in which they are found, rather than building a list of
+// tokens. If fn returns an error, lexing stops and that error is
+// returned wrapped in a CallbackError, so that it may be distinguished
+// from errors encountered by the lexer itself.
+func (l *Lexer) LexFunc(input io.Reader, fn func(Token) error) Error {
+	return l.lex(input, func(token Token) Error {
+		if err := fn(token); err != nil {
+			return newCallbackError(err)
+		}
+		return nil
+	}, nil)
+}
+
+// LexString lexically analyses a string in the same way as Lex.
+func (l *Lexer) LexString(input string) (TokenList, Error) {
+	return l.Lex(strings.NewReader(input))
+}
+
+// LexBytes lexically analyses a byte slice in the same way as Lex.
+// The slice is lexed directly, rather than being copied, so it
+// should not be modified until LexBytes returns. This makes it
+// suitable for large inputs which are already in memory, such as
+// memory-mapped files. The values of the tokens are copied from the
+// slice, even with the WithInterning option, so the slice need not be
+// kept once LexBytes has returned.
+func (l *Lexer) LexBytes(input []byte) (TokenList, Error) {
+	list, _, err := l.LexN(input)
+	return list, err
+}
+
+// LexN lexically analyses a byte slice in the same way as LexBytes,
+// and also returns the number of bytes of the slice which were
+// consumed, including any whitespace skipped after the last token, so
+// that a caller lexing one part of a larger buffer at a time can
+// resume from that point. If lexing stops with an error, the number
+// of bytes is that consumed before the error was encountered.
+func (l *Lexer) LexN(input []byte) (TokenList, int, Error) {
+	if l.maxInputBytes > 0 && len(input) > l.maxInputBytes {
+		return nil, 0, newInputTooLargeError(l.maxInputBytes)
 	}
 
-	buffer := indexedBuffer{bytes, 0}
+	list := TokenList{}
+	buffer := newIndexedBuffer(input, &l.config)
+
+	err := l.lexBuffer(buffer, func(token Token) Error {
+		list = append(list, token)
+		return nil
+	}, nil)
+
+	return list, buffer.index, err
+}
+
+// LexFrom lexically analyses a byte slice in the same way as LexBytes,
+// but starting from the provided byte offset rather than from the
+// start of the slice, such as to re-lex only the part of an input
+// after a known token boundary. The Index, Line and Column of each
+// token are positions in the whole slice, as if it had been lexed from
+// the start. A StartError is returned if the offset is outside the
+// slice.
+func (l *Lexer) LexFrom(input []byte, start int) (TokenList, Error) {
+	if start < 0 || start > len(input) {
+		return nil, newStartError(start, len(input))
+	}
+	if l.maxInputBytes > 0 && len(input) > l.maxInputBytes {
+		return nil, newInputTooLargeError(l.maxInputBytes)
+	}
 
 	list := TokenList{}
+	buffer := newIndexedBuffer(input, &l.config)
+	buffer.advance(start)
+	buffer.bomDone = start > 0
+
+	err := l.lexBuffer(buffer, func(token Token) Error {
+		list = append(list, token)
+		return nil
+	}, nil)
+
+	return list, err
+}
+
+// LexComplete lexically analyses the input in the same way as Lex, but
+// as if the lexer was created with the WithRequireFullMatch option, so
+// that a TrailingInputError is returned if any of the input is left
+// unconsumed.
+func (l *Lexer) LexComplete(input io.Reader) (TokenList, Error) {
+	complete := *l
+	complete.requireFullMatch = true
+	return complete.Lex(input)
+}
+
+// LexFile lexically analyses the contents of the file at the provided
+// path in the same way as Lex, as if the lexer was created with the
+// WithFilename option and the path, so that any MatchError is labelled
+// with it. An InputError is returned if the file can't be
+// opened. The file is always closed before LexFile returns.
+func (l *Lexer) LexFile(path string) (TokenList, Error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, newInputError(err, 0)
+	}
+	defer file.Close()
+
+	return l.named(path).Lex(file)
+}
+
+// named returns a copy of the lexer which labels tokens and errors
+// with the provided filename, as with the WithFilename option.
+func (l *Lexer) named(filename string) *Lexer {
+	lexer := *l
+	lexer.filename = filename
+	if l.modes != nil {
+		lexer.modes = make(map[string]*Lexer, len(l.modes))
+		for mode, modeLexer := range l.modes {
+			lexer.modes[mode] = modeLexer.named(filename)
+		}
+	}
+	return &lexer
+}
+
+// LexMulti lexically analyses a number of inputs in order as a single
+// unit, returning a list of tokens for each of them, in the same order
+// as the inputs. Each input is lexed separately, so that a token never
+// spans two inputs, and the positions of tokens are relative to the
+// start of their own input. If the lexer was created with the
+// WithEOFToken option, only one EOF token is returned, at the end of
+// the list for the last input. A MatchError returned from LexMulti has
+// the Source of the input which could not be matched, and is returned
+// with the lists for the inputs up to and including that one, which
+// contain every token found before it, as for Lex.
+func (l *Lexer) LexMulti(inputs ...io.Reader) ([]TokenList, Error) {
+	lists := make([]TokenList, 0, len(inputs))
+
+	for source, input := range inputs {
+		last := source == len(inputs)-1
+		list := TokenList{}
+		err := l.lex(input, func(token Token) Error {
+			if token.ID == EOF && !last {
+				return nil
+			}
+			list = append(list, token)
+			return nil
+		}, nil)
+		lists = append(lists, list)
+
+		if merr, ok := err.(MatchError); ok {
+			merr.Source = source
+			return lists, merr
+		} else if err != nil {
+			return lists, err
+		}
+	}
+
+	return lists, nil
+}
+
+// LexRecover lexically analyses the input in the same way as Lex,
+// but rather than stopping at the first input which cannot be
+// matched, it records a MatchError, skips forward one rune, and
+// tries again, repeating until a match is found. Only one MatchError
+// is recorded for each contiguous region of unmatched input, and its
+// Index is the position at which that region starts. The returned
+// list contains every token which was successfully matched, and the
+// returned slice contains a MatchError for each unmatched region, in
+// the order in which they were found. An error is returned only if
+// the input could not be read.
+func (l *Lexer) LexRecover(input io.Reader) (TokenList, []MatchError,
+	Error) {
+	list := TokenList{}
+	var errs []MatchError
+
+	if err := l.lex(input, func(token Token) Error {
+		list = append(list, token)
+		return nil
+	}, func(err MatchError) {
+		errs = append(errs, err)
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	return list, errs, nil
+}
+
+// LexChannel lexically analyses the input in a separate goroutine,
+// sending each token on the returned token channel as soon as it is
+// found. Each token is sent before the next one is matched, so a
+// slow consumer will slow the lexer down rather than cause tokens to
+// accumulate. When lexing is complete, the token channel is closed,
+// and any error which caused lexing to stop is sent on the error
+// channel before it is also closed. The caller must drain both
+// channels, in that order, or the goroutine will never exit.
+func (l *Lexer) LexChannel(input io.Reader) (<-chan Token, <-chan error) {
+	tokens := make(chan Token)
+	errs := make(chan error, 1)
+
+	go func() {
+		err := l.lex(input, func(token Token) Error {
+			tokens <- token
+			return nil
+		}, nil)
+		close(tokens)
+		if err != nil {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	return tokens, errs
+}
+
+// lex lexically analyses the input, calling emit for each token
+// in the order in which they are found. If emit returns an error,
+// lexing stops and that error is returned. If recovered is not nil,
+// it is called with the MatchError for each region of unmatched
+// input, which is then skipped one rune at a time, rather than
+// lexing stopping with that error.
+func (l *Lexer) lex(input io.Reader, emit func(Token) Error,
+	recovered func(MatchError)) Error {
+	buffer, err := l.newBuffer(input)
+	if err != nil {
+		return err
+	}
+	return l.lexBuffer(buffer, emit, recovered)
+}
+
+// newBuffer returns a buffer for lexing the input, which is read
+// incrementally if the lexer was created with the WithBufferSize
+// option, and otherwise is read entirely at once.
+func (l *Lexer) newBuffer(input io.Reader) (*indexedBuffer, Error) {
+	input = l.limit(input)
+
+	if l.bufferSize > 0 {
+		return newWindowedBuffer(input, l.bufferSize, &l.config), nil
+	}
+
+	bytes, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, readError(err, len(bytes))
+	}
+
+	return newIndexedBuffer(bytes, &l.config), nil
+}
+
+// limit returns a reader which reads from the input, but which
+// returns an InputTooLargeError if the input exceeds the maximum
+// size, if there is one.
+func (l *Lexer) limit(input io.Reader) io.Reader {
+	if l.maxInputBytes <= 0 {
+		return input
+	}
+	return &limitedReader{reader: input, limit: l.maxInputBytes}
+}
+
+// lexBuffer lexically analyses the contents of a buffer in the
+// same way as lex.
+func (l *Lexer) lexBuffer(buffer *indexedBuffer, emit func(Token) Error,
+	recovered func(MatchError)) Error {
+	resumed := -1
+	count := 0
 
 	for {
-		buffer.skipWhitespace(l.skipNewline)
-		if buffer.endOfInput() {
+		token, ok, err := l.scan(buffer)
+		if merr, isMatch := err.(MatchError); isMatch && recovered != nil {
+
+			// If we failed to match immediately after skipping
+			// a rune, we're still in the same unmatched region,
+			// so don't report it again.
+
+			if merr.Index != resumed {
+				recovered(merr)
+			}
+			if err := buffer.skipRune(); err != nil {
+				return err
+			}
+			resumed = buffer.offset()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !ok {
 			break
 		}
+		if count++; l.maxTokens > 0 && count > l.maxTokens {
+			return newTokenLimitError(l.maxTokens, token.Index)
+		}
+		if err := emit(token); err != nil {
+			return err
+		}
+	}
+
+	// Unless it stopped at a stop pattern, scanning only stops at
+	// the end of the input, but check that all the input was consumed
+	// rather than assuming it, if we were asked to.
+
+	if l.requireFullMatch && !buffer.stopped && !buffer.endOfInput() {
+		return newTrailingInputError(buffer.offset())
+	}
+
+	return nil
+}
+
+// scan gets the next token from a buffer, skipping any whitespace
+// before it, and any byte order mark at the start of the input if
+// required, and labels it with its kind and the filename, if there is
+// one.
+func (l *Lexer) scan(b *indexedBuffer) (Token, bool, Error) {
+	if b.stopped {
+		return Token{}, false, nil
+	}
+	if l.stripBOM {
+		if err := b.skipBOM(); err != nil {
+			return Token{}, false, err
+		}
+	}
+
+	if l.modes != nil {
+		return l.scanModal(b)
+	}
+
+	scan := l.scanLexemes
+	if l.indentation {
+		scan = l.scanIndented
+	}
+	token, ok, err := scan(b)
+	if ok && l.collapseNewlines && token.ID == l.newline && l.newline != -1 {
+		err = l.collapseNewline(b, &token)
+	}
+	if ok && l.stop && token.ID == l.stopID {
+		b.stopped = true
+		if !l.stopInclude {
+			return Token{}, false, err
+		}
+	}
+	if ok {
+		if l.kinds != nil {
+			token.Kind = l.kind(token.ID)
+		}
+		if l.stats != nil {
+			l.stats.token()
+		}
+	}
+	return token, ok, err
+}
+
+// scanIndented gets the next token from a buffer in the same way as
+// scanLexemes, preceded by any Indent or Dedent tokens, for the
+// WithIndentation option. The width of the indentation of a line is
+// compared with the enclosing levels when the first token on the line
+// is found, so lines which are blank, or which only contain whitespace
+// and skip patterns, don't count. Each level still open at the end of
+// the input is closed by a Dedent token before any EOF token.
+func (l *Lexer) scanIndented(b *indexedBuffer) (Token, bool, Error) {
+	if len(b.pending) > 0 {
+		token := b.pending[0]
+		b.pending = b.pending[1:]
+		return token, true, nil
+	}
+
+	token, ok, err := l.scanLexemes(b)
+	if err != nil {
+		return token, ok, err
+	}
+
+	if !ok || token.ID == EOF {
+		if len(b.levels) == 0 {
+			return token, ok, nil
+		}
+		dedent := Token{ID: Dedent, Index: b.offset(), Line: b.line,
+			Column: b.column, End: b.offset()}
+		if ok {
+			dedent.Index, dedent.End = token.Index, token.Index
+			dedent.Line, dedent.Column = token.Line, token.Column
+		}
+		for range b.levels {
+			b.pending = append(b.pending, dedent)
+		}
+		if ok {
+			b.pending = append(b.pending, token)
+		}
+		b.levels = nil
+		return l.scanIndented(b)
+	}
 
-		token, err := l.getNextToken(&buffer)
+	if !b.leading || token.ID == Whitespace || token.ID == Comment ||
+		(token.ID == l.newline && l.newline != -1) {
+		return token, true, nil
+	}
+	b.leading = false
+
+	marker := Token{Index: token.Index, Line: token.Line,
+		Column: token.Column, End: token.Index}
+	level := 0
+	if len(b.levels) > 0 {
+		level = b.levels[len(b.levels)-1]
+	}
+
+	switch {
+	case b.width > level:
+		b.levels = append(b.levels, b.width)
+		marker.ID = Indent
+		b.pending = append(b.pending, marker)
+	case b.width < level:
+		marker.ID = Dedent
+		for len(b.levels) > 0 && b.levels[len(b.levels)-1] > b.width {
+			b.levels = b.levels[:len(b.levels)-1]
+			b.pending = append(b.pending, marker)
+		}
+		level = 0
+		if len(b.levels) > 0 {
+			level = b.levels[len(b.levels)-1]
+		}
+		if level != b.width {
+			b.pending = nil
+			return Token{}, false, newIndentationError(token.Index,
+				token.Line, b.width)
+		}
+	}
+
+	b.pending = append(b.pending, token)
+	return l.scanIndented(b)
+}
+
+// collapseNewline advances the buffer past any newline characters
+// which follow a newline token, along with any whitespace between them,
+// unless trivia tokens are required, and extends the token to cover
+// them, for the WithCollapseNewlines option.
+func (l *Lexer) collapseNewline(b *indexedBuffer, token *Token) Error {
+	for {
+		if !l.trivia {
+			if err := b.skipWhitespace(false, l.isSpace); err != nil {
+				return err
+			}
+		}
+		if b.endOfInput() {
+			break
+		}
+
+		length := 0
+		if l.normalizeNewlines {
+			n, err := b.carriageReturn()
+			if err != nil {
+				return err
+			}
+			length = n
+		}
+		if length == 0 {
+			id, n, err := l.match(b)
+			if err != nil {
+				return err
+			} else if id != l.newline || n <= 0 {
+				break
+			}
+			length = n
+		}
+
+		b.advance(length)
+		if l.stats != nil {
+			l.stats.match(l.newline)
+		}
+	}
+
+	token.End = b.offset()
+	return nil
+}
+
+// scanLexemes gets the next token from a buffer for scan. Matches
+// of skip patterns advance the buffer, but produce no token, so
+// scanning continues after them. The returned bool is false if the
+// end of the input was reached before another token was found, after
+// any EOF token has been returned. If trivia tokens are required, any
+// whitespace skipped is returned as one.
+func (l *Lexer) scanLexemes(b *indexedBuffer) (Token, bool, Error) {
+	for {
+
+		// If we're preserving whitespace, pin the buffer so that
+		// whitespace isn't discarded if more input is read while
+		// we're skipping it, and return it as a trivia token.
+
+		start := b.offset()
+		if l.trivia {
+			line, column := b.line, b.column
+			b.pin()
+			err := b.skipWhitespace(l.skipNewline, l.isSpace)
+			if skipped := b.unpin(); err == nil && len(skipped) > 0 {
+				if l.tracer != nil {
+					l.trace("at %d: whitespace up to %d\n", start,
+						b.offset())
+				}
+				return Token{ID: Whitespace, Value: l.value(skipped),
+					Index: start, Line: line, Column: column,
+					End: b.offset()}, true, nil
+			} else if err != nil {
+				return Token{}, false, err
+			}
+		} else if err := b.skipWhitespace(l.skipNewline,
+			l.isSpace); err != nil {
+			return Token{}, false, err
+		}
+		if l.tracer != nil && b.offset() > start {
+			l.trace("at %d: skipped whitespace up to %d\n", start,
+				b.offset())
+		}
+
+		if b.endOfInput() {
+			if l.tracer != nil && !b.finished {
+				l.trace("at %d: end of input\n", b.offset())
+			}
+
+			// Produce an EOF token, if required, the first time
+			// we reach the end of the input.
+
+			if l.eofToken && !b.finished {
+				b.finished = true
+				return Token{ID: EOF, Index: b.offset(), Line: b.line,
+					Column: b.column, End: b.offset()}, true, nil
+			}
+			return Token{}, false, nil
+		}
+
+		// If there is a nested comment here, skip it, or return it
+		// as a trivia token if we're preserving trivia.
+
+		if l.commentOpen != "" {
+			index, line, column := b.offset(), b.line, b.column
+			if l.trivia {
+				b.pin()
+			}
+			skipped, err := l.skipNestedComment(b)
+			var value []byte
+			if l.trivia {
+				value = b.unpin()
+			}
+			if err != nil {
+				return Token{}, false, err
+			} else if skipped {
+				if l.tracer != nil {
+					l.trace("at %d: nested comment up to %d\n", index,
+						b.offset())
+				}
+				if l.trivia {
+					return Token{ID: Comment, Value: l.value(value),
+						Index: index, Line: line, Column: column,
+						End: b.offset()}, true, nil
+				}
+				continue
+			}
+		}
+
+		// If we're normalizing newlines and the newline character
+		// is one of the lexemes, a carriage return, alone or
+		// followed by a newline character, is a newline token.
+
+		if l.normalizeNewlines && l.newline != -1 {
+			n, err := b.carriageReturn()
+			if err != nil {
+				return Token{}, false, err
+			} else if n > 0 {
+				token := Token{ID: l.newline, Name: l.Name(l.newline),
+					Value: "\n", Index: b.offset(), Line: b.line,
+					Column: b.column}
+				b.advance(n)
+				token.End = b.offset()
+				if l.tracer != nil {
+					l.traceToken(token)
+				}
+				if l.stats != nil {
+					l.stats.match(token.ID)
+				}
+				return token, true, nil
+			}
+		}
+
+		b.leading, b.width = !b.indented, b.indent
+		token, err := l.getNextToken(b)
+		if _, ok := err.(MatchError); ok && l.fallback {
+			token, err = l.fallbackToken(b)
+		} else if ok && l.errorToken {
+			token, err = l.unmatchedToken(b)
+		}
 		if err != nil {
-			return nil, err
+			if l.tracer != nil {
+				l.trace("at %d: %v\n", b.offset(), err)
+			}
+			return Token{}, false, err
+		}
+
+		if l.tracer != nil {
+			l.traceToken(token)
+		}
+		if l.stats != nil && token.ID >= 0 {
+			l.stats.match(token.ID)
+		}
+		if token.ID < len(l.lexemes) {
+			return token, true, nil
+		}
+	}
+}
+
+// skipNestedComment advances the buffer past the nested comment at the
+// current index, for the WithNestedComment option, and reports whether
+// there was one.
+func (l *Lexer) skipNestedComment(b *indexedBuffer) (bool, Error) {
+	if ok, err := b.hasPrefix(l.commentOpen); !ok || err != nil {
+		return false, err
+	}
+	index, line, column := b.offset(), b.line, b.column
+	b.advance(len(l.commentOpen))
+
+	for depth := 1; depth > 0; {
+		if ok, err := b.hasPrefix(l.commentClose); err != nil {
+			return false, err
+		} else if ok {
+			b.advance(len(l.commentClose))
+			depth--
+			continue
+		}
+		if ok, err := b.hasPrefix(l.commentOpen); err != nil {
+			return false, err
+		} else if ok {
+			b.advance(len(l.commentOpen))
+			depth++
+			continue
+		}
+		if b.endOfInput() {
+			return false, newUnterminatedCommentError(l.filename, index,
+				line, column)
+		}
+		if err := b.skipRune(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// possiblyIncomplete checks if the input from the current index to the
+// end of the input is the start of a match for any of the patterns, so
+// that it failed to match because the input ended too soon. If input
+// is being read incrementally, more is read while what there is might
+// be the start of a match.
+func (l *Lexer) possiblyIncomplete(b *indexedBuffer) bool {
+	prefixes := l.prefixMatcher()
+	for {
+		viable := prefixes.viable(b.next())
+		if !viable || b.complete() {
+			return viable
+		}
+		if err := b.fill(); err != nil {
+			return false
 		}
-		list = append(list, token)
 	}
+}
 
-	return list, nil
+// trace writes a line describing a step in lexing to the tracer.
+// Errors writing to the tracer are ignored, since tracing is only
+// for diagnosis. This should only be called if there is a tracer,
+// to avoid the cost of formatting otherwise.
+func (l *Lexer) trace(format string, args ...interface{}) {
+	fmt.Fprintf(l.tracer, format, args...)
+}
+
+// traceToken writes a line describing a token which was found to
+// the tracer, noting if it was matched by a skip pattern.
+func (l *Lexer) traceToken(token Token) {
+	skipped := ""
+	if token.ID >= len(l.lexemes) {
+		skipped = ", skipped"
+	}
+	l.trace("at %d: pattern %d matched %q, length %d%s\n", token.Index,
+		token.ID, token.Value, len(token.Value), skipped)
 }
 
 // getNextToken gets the next token from a buffer.
 func (l *Lexer) getNextToken(b *indexedBuffer) (Token, Error) {
+	id, length, err := l.match(b)
+	if err != nil {
+		return Token{}, err
+	}
+	groups := b.groups
 
-	// Check if there was a match.
+	if length == -1 {
 
-	result := l.regexps.FindAllSubmatchIndex(b.next(), 1)
-	if len(result) == 0 {
-		return Token{-1, string(b.current()), b.index},
-			newMatchError(b.index)
+		// Read enough of the input for the context of the error to
+		// be the same however much of it has been read so far.
+
+		if err := b.fillRunes(matchErrorContextLength); err != nil {
+			return Token{}, err
+		}
+		merr := newMatchError(l.filename, b.offset(), b.line, b.column,
+			b.next()).(MatchError)
+		if !l.fallback && !l.errorToken {
+			merr.PossiblyIncomplete = l.possiblyIncomplete(b)
+		}
+		return Token{}, merr
 	}
-	matches := result[0]
 
-	// Loop over the number of subexpressions, which may be different
-	// from the number of lexeme patterns initially provided to the
-	// lexer if any of the lexeme patterns themselves contain
-	// parenthesized capturing groups.
+	// A pattern which matches the empty string is rejected when the
+	// lexer is created, but some patterns, such as \b, match the empty
+	// string only at certain positions. A token which consumed no input
+	// would leave us at the same position to match it again forever.
 
-	for i := 0; i < l.regexps.NumSubexp(); i++ {
-		beg, end := matches[2*(i+1)], matches[2*(i+1)+1]
+	if length == 0 {
+		return Token{}, newZeroWidthMatchError(id, b.offset())
+	}
 
-		if beg == -1 {
+	// We found a match, so check it isn't too long, and then advance
+	// the buffer and return a constructed token.
 
-			// There was no match for this subexpression.
+	if l.maxTokenBytes > 0 && length > l.maxTokenBytes {
+		return Token{}, newTokenTooLongError(b.offset(), length)
+	}
 
-			continue
+	token := Token{ID: id, Name: l.Name(id), Value: b.substring(length),
+		Index: b.offset(), Line: b.line, Column: b.column}
+	if groups != nil {
+		token.groups = &groups
+	}
+	value := token.Value
+	if l.withoutValues {
+		if id == l.newline {
+			token.Value = "\n"
 		}
+		if l.keywords != nil {
+			value = string(b.next()[:length])
+		}
+	}
+	if keyword, ok := l.keyword(id, value); ok {
+		token.ID, token.Name = keywordPatternID, l.Name(keywordPatternID)
+		token.Sub = keyword
+	}
+	if decode, ok := l.decoders[id]; ok && !l.withoutValues {
+		decoded, err := decode(token.Value)
+		if err != nil {
+			return Token{}, newDecodeError(l.filename, token, err)
+		}
+		token.Raw, token.Value = token.Value, decoded
+	}
+	b.advance(length)
+	token.End = b.offset()
+	return token, nil
+}
 
-		// There was a match for this subexpression, but we need to
-		// check if it has a name, by attempting to convert it to a
-		// number (all our named capturing groups are named by
-		// sequential numbers). If the user inexplicably named
-		// any of the parenthesized capturing groups in their
-		// lexeme patterns, then we may be out of luck.
+// fallbackToken returns a token with the fallback ID containing the
+// rune at the current index of the buffer, at which no pattern matches.
+func (l *Lexer) fallbackToken(b *indexedBuffer) (Token, Error) {
+	if err := b.fillRune(); err != nil {
+		return Token{}, err
+	}
+	_, size := utf8.DecodeRune(b.next())
 
-		dn, err := strconv.ParseInt(l.regexps.SubexpNames()[i+1], 10, 32)
-		if err != nil {
+	token := Token{ID: l.fallbackID, Name: l.Name(l.fallbackID),
+		Value: b.substring(size), Index: b.offset(), Line: b.line,
+		Column: b.column}
+	b.advance(size)
+	token.End = b.offset()
+	return token, nil
+}
+
+// value returns the value of a token containing the provided bytes,
+// which is the empty string if the lexer was created with the
+// WithoutValues option.
+func (l *Lexer) value(lexeme []byte) string {
+	if l.withoutValues {
+		return ""
+	}
+	return string(lexeme)
+}
 
-			// We matched a parenthesized subexpression within
-			// one of the lexeme patterns initially provided,
-			// and not an entire lexeme pattern, so continue to
-			// look for a match.
+// unmatchedToken returns an Unmatched token containing the input from
+// the current index of the buffer, at which no pattern matches, up to
+// the next rune which is whitespace or at which a pattern matches, or
+// to the end of the input.
+func (l *Lexer) unmatchedToken(b *indexedBuffer) (Token, Error) {
+	token := Token{ID: Unmatched, Index: b.offset(), Line: b.line,
+		Column: b.column}
+	b.pin()
 
-			continue
+	for {
+		if err := b.skipRune(); err != nil {
+			b.unpin()
+			return Token{}, err
+		}
+		if err := b.fillRune(); err != nil {
+			b.unpin()
+			return Token{}, err
+		}
+		if b.endOfInput() {
+			break
 		}
 
-		// We found a match, so advance the buffer and return
-		// a constructed token.
+		if r, _ := utf8.DecodeRune(b.next()); l.isSpace(r) {
+			break
+		}
+		if _, length, err := l.match(b); err != nil {
+			b.unpin()
+			return Token{}, err
+		} else if length != -1 {
+			break
+		}
+	}
+
+	token.Value = l.value(b.unpin())
+	token.End = b.offset()
+	return token, nil
+}
 
-		token := Token{int(dn), b.substring(end - beg), b.index}
-		b.advance(end - beg)
-		return token, nil
+// match finds the pattern which matches at the current index of the
+// buffer, and returns its id and the length of the match, or a length
+// of -1 if no pattern matches, without advancing the buffer. If the
+// buffer is being lexed by LexContext and its context is done, a
+// ContextError is returned instead.
+func (l *Lexer) match(b *indexedBuffer) (int, int, Error) {
+	if b.ctx != nil {
+		if err := b.ctx.Err(); err != nil {
+			return 0, 0, newContextError(err)
+		}
+	}
+	if l.stats != nil {
+		start := time.Now()
+		id, length, err := l.matchStep(b)
+		l.stats.step(time.Since(start))
+		return id, length, err
 	}
+	return l.matchStep(b)
+}
 
-	// If we got here then we matched the expression but
-	// failed to identify the match, which shouldn't happen.
+// matchStep finds the pattern which matches in the same way as match,
+// with any step timeout, but without counting the step.
+func (l *Lexer) matchStep(b *indexedBuffer) (int, int, Error) {
+	if l.stepTimeout > 0 {
+		return l.matchTimeout(b)
+	}
+	return l.matchNow(b)
+}
+
+// matchNow finds the pattern which matches in the same way as match,
+// without any timeout. If the lexer has forced boundaries, the patterns
+// only see the input up to the next boundary rune, as if the input
+// ended there, so that no match can extend beyond it.
+func (l *Lexer) matchNow(b *indexedBuffer) (int, int, Error) {
+	if len(l.boundaries) == 0 {
+		return l.matchPatterns(b)
+	}
+
+	length, err := b.boundaryLength(l.isBoundary)
+	if err != nil {
+		return 0, 0, err
+	} else if length == -1 {
+		return l.matchPatterns(b)
+	}
+
+	buffer, reader := b.buffer, b.reader
+	b.buffer, b.reader = b.buffer[:b.index+length], nil
+	id, length, err := l.matchPatterns(b)
+	b.buffer, b.reader = buffer, reader
+	return id, length, err
+}
 
-	panic("failed to find regex match index")
+// isBoundary checks if a rune is one of the forced boundaries of the
+// lexer.
+func (l *Lexer) isBoundary(r rune) bool {
+	for _, boundary := range l.boundaries {
+		if r == boundary {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPatterns finds the pattern which matches in the same way as
+// match, using the trie of literal patterns, if there is one, or
+// otherwise the combined expression.
+func (l *Lexer) matchPatterns(b *indexedBuffer) (int, int, Error) {
+	if l.literals != nil {
+		return l.matchLiteral(b)
+	}
+	if l.dispatch != nil {
+		id, length, ok, err := l.dispatch.match(b)
+		if ok || err != nil {
+			return id, length, err
+		}
+	}
+	if l.optimized != nil {
+		return l.optimized.match(b)
+	}
+	return l.matchRegexp(b)
+}
+
+// matchTimeout finds the pattern which matches in the same way as
+// match, but in a separate goroutine, returning a TimeoutError if it
+// takes longer than the step timeout. The goroutine can't be stopped,
+// so it is left to finish, and the buffer must not be used again after
+// a timeout, since the goroutine may still be using it. The input is
+// read through a stepReader for the step, so that the goroutine stops
+// reading the input once the step has timed out, other than to finish
+// any read already in progress.
+func (l *Lexer) matchTimeout(b *indexedBuffer) (int, int, Error) {
+	type result struct {
+		id, length int
+		err        Error
+	}
+
+	var reader *stepReader
+	if !b.complete() {
+		reader = &stepReader{reader: b.reader}
+		b.reader = reader
+	}
+
+	offset := b.offset()
+	done := make(chan result, 1)
+	go func() {
+		id, length, err := l.matchNow(b)
+		done <- result{id, length, err}
+	}()
+
+	timer := time.NewTimer(l.stepTimeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		if reader != nil && b.reader != nil {
+			b.reader = reader.reader
+		}
+		return r.id, r.length, r.err
+	case <-timer.C:
+		if reader != nil {
+			atomic.StoreInt32(&reader.stopped, 1)
+		}
+		return 0, 0, newTimeoutError(offset, l.stepTimeout)
+	}
+}
+
+// stepReader reads from the input of a lexer for a single step with a
+// timeout, failing every read once the step has timed out.
+type stepReader struct {
+	reader  io.Reader
+	stopped int32
+}
+
+// Read reads from the input, unless the step has timed out.
+func (r *stepReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.stopped) != 0 {
+		return 0, errStepTimedOut
+	}
+	return r.reader.Read(p)
+}
+
+// errStepTimedOut is returned by a stepReader once the step has timed
+// out, and is never returned to the caller of the lexer.
+var errStepTimedOut = errors.New("step timed out")
+
+// matchRegexp finds the lexeme pattern which matches at the current
+// index of the buffer using the combined regular expression, and
+// returns its id and the length of the match, or a length of -1 if
+// no pattern matches.
+func (l *Lexer) matchRegexp(b *indexedBuffer) (int, int, Error) {
+
+	// Check if there was a match. If we're reading the input
+	// incrementally, match against a rune reader which reads more
+	// input into the buffer on demand, so that the regular
+	// expression engine can look as far ahead as it needs to in
+	// order to find the longest match.
+
+	var matches []int
+	if b.complete() {
+		matches = l.regexps.FindSubmatchIndex(b.next())
+	} else {
+		reader := b.runeReader()
+		matches = l.regexps.FindReaderSubmatchIndex(reader)
+		if reader.err != nil {
+			return 0, 0, reader.err
+		}
+	}
+
+	b.groups = nil
+	if matches == nil {
+		return 0, -1, nil
+	}
+
+	// Loop over our capturing groups, one for each lexeme pattern,
+	// to find the one which matched.
+
+	for id, group := range l.groups {
+		beg, end := matches[2*group], matches[2*group+1]
+
+		if beg == -1 {
+
+			// There was no match for this lexeme pattern.
+
+			continue
+		}
+
+		if l.recordGroups && id < len(l.anchored) {
+			b.groups = l.matchedGroups(b, matches, group,
+				l.anchored[id].NumSubexp())
+		}
+		return id, end - beg, nil
+	}
+
+	// If we got here then we matched the expression but failed to
+	// identify the match, which shouldn't happen, but is better
+	// reported than allowed to crash a program lexing untrusted
+	// input.
+
+	return 0, 0, newInternalMatchError(b.offset(),
+		string(b.next()[matches[0]:matches[1]]))
+}
+
+// matchedGroups returns the text matched by each of the n capturing
+// groups of a lexeme pattern which follow its own group in the combined
+// expression, or the empty string for a group which did not match, or
+// nil if there are none.
+func (l *Lexer) matchedGroups(b *indexedBuffer, matches []int,
+	group, n int) []string {
+	if n == 0 {
+		return nil
+	}
+
+	next := b.next()
+	groups := make([]string, n)
+	for i := range groups {
+		beg, end := matches[2*(group+1+i)], matches[2*(group+1+i)+1]
+		if beg != -1 {
+			groups[i] = string(next[beg:end])
+		}
+	}
+	return groups
 }
@@ -1,6 +1,10 @@
 package lexer
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
 
 // Error is an interface for lexer error types.
 type Error interface {
@@ -32,31 +36,690 @@ type MatchError struct {
 	// Index is the index in the input where the matching failure
 	// occurred.
 	Index int
+	// Line is the line of the input, starting at 1, on which the
+	// matching failure occurred.
+	Line int
+	// Column is the position within its line, in runes, starting
+	// at 1, at which the matching failure occurred.
+	Column int
+	// Context is a short run of the input starting at the position
+	// where the matching failure occurred.
+	Context string
+	// Filename is the name of the input, if one was provided with
+	// the WithFilename option.
+	Filename string
+	// Source is the index of the input in which the matching
+	// failure occurred, if it occurred in LexMulti.
+	Source int
+	// PossiblyIncomplete is true if the rest of the input from the
+	// position where the matching failure occurred is the start of
+	// a match for one of the patterns, such as an unterminated
+	// string literal, so that the input might have matched if there
+	// had been more of it, rather than containing something which
+	// no pattern matches.
+	PossiblyIncomplete bool
 }
 
-func newMatchError(index int) Error {
-	return MatchError{index}
+// matchErrorContextLength is the maximum number of runes of input
+// in the context of a MatchError.
+const matchErrorContextLength = 20
+
+func newMatchError(filename string, index, line, column int,
+	context []byte) Error {
+	n := 0
+	for i := 0; i < matchErrorContextLength && n < len(context); i++ {
+		_, size := utf8.DecodeRune(context[n:])
+		n += size
+	}
+	return MatchError{Index: index, Line: line, Column: column,
+		Context: string(context[:n]), Filename: filename}
 }
 
-// Error returns a string representation of a MatchError.
+// Error returns a string representation of a MatchError. If the
+// error has a filename, the message starts with the filename, line
+// and column in the conventional file:line:column form.
 func (e MatchError) Error() string {
-	return fmt.Sprintf("couldn't match input at position %d", e.Index)
+	if e.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d: couldn't match input: %q",
+			e.Filename, e.Line, e.Column, e.Context)
+	}
+	return fmt.Sprintf("couldn't match input at line %d, column %d: %q",
+		e.Line, e.Column, e.Context)
 }
 
 func (e MatchError) implementsError() {}
 
+// EditRangeError is returned when TokenList.Splice is asked to splice
+// in an edit which doesn't fit the input after it.
+type EditRangeError struct {
+	// Start is the offset at which the edit starts.
+	Start int
+	// End is the offset in the input before the edit at which the
+	// edit ends.
+	End int
+	// NewLength is the length of the text which the edit inserted.
+	NewLength int
+	// Length is the length of the input after the edit.
+	Length int
+}
+
+func newEditRangeError(start, end, newLength, length int) Error {
+	return EditRangeError{start, end, newLength, length}
+}
+
+// Error returns a string representation of an EditRangeError.
+func (e EditRangeError) Error() string {
+	return fmt.Sprintf("edit replacing %d to %d with %d bytes doesn't fit "+
+		"input of %d bytes", e.Start, e.End, e.NewLength, e.Length)
+}
+
+func (e EditRangeError) implementsError() {}
+
+// DecodeError is returned when the decoder provided with the
+// WithDecoder option for a lexeme pattern fails to decode a lexeme.
+type DecodeError struct {
+	// ID is the ID of the lexeme pattern which identified the lexeme.
+	ID int
+	// Value is the text of the lexeme.
+	Value string
+	// Index is the index in the input at which the lexeme was found.
+	Index int
+	// Line is the line of the input, starting at 1, on which the
+	// lexeme was found.
+	Line int
+	// Column is the position of the lexeme within its line, in runes,
+	// starting at 1.
+	Column int
+	// Filename is the name of the input, if one was provided with
+	// the WithFilename option.
+	Filename string
+	dErr     error
+}
+
+func newDecodeError(filename string, token Token, err error) Error {
+	return DecodeError{ID: token.ID, Value: token.Value, Index: token.Index,
+		Line: token.Line, Column: token.Column, Filename: filename,
+		dErr: err}
+}
+
+// Error returns a string representation of a DecodeError.
+func (e DecodeError) Error() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d: couldn't decode %q: %v", e.Filename,
+			e.Line, e.Column, e.Value, e.dErr)
+	}
+	return fmt.Sprintf("couldn't decode %q at line %d, column %d: %v",
+		e.Value, e.Line, e.Column, e.dErr)
+}
+
+// Unwrap returns the error returned by the decoder.
+func (e DecodeError) Unwrap() error {
+	return e.dErr
+}
+
+func (e DecodeError) implementsError() {}
+
+// EmptyMatchPatternError is returned when the lexer is created with a
+// lexeme pattern which matches the empty string.
+type EmptyMatchPatternError struct {
+	// Index is the index of the pattern, counting any skip patterns
+	// as following the lexeme patterns.
+	Index int
+	// Pattern is the source of the pattern, which may itself be
+	// empty.
+	Pattern string
+}
+
+func newEmptyMatchPatternError(index int, pattern string) Error {
+	return EmptyMatchPatternError{index, pattern}
+}
+
+// Error returns a string representation of an EmptyMatchPatternError.
+func (e EmptyMatchPatternError) Error() string {
+	if e.Pattern == "" {
+		return fmt.Sprintf("lexeme pattern %d is empty", e.Index)
+	}
+	return fmt.Sprintf("lexeme pattern %d %q matches the empty string",
+		e.Index, e.Pattern)
+}
+
+func (e EmptyMatchPatternError) implementsError() {}
+
+// FallbackIDError is returned when the lexer is created with the
+// WithFallbackID option and an ID which is not that of one of its
+// lexeme patterns.
+type FallbackIDError struct {
+	// ID is the ID provided with the option.
+	ID int
+	// Patterns is the number of lexeme patterns provided.
+	Patterns int
+}
+
+func newFallbackIDError(id, patterns int) Error {
+	return FallbackIDError{id, patterns}
+}
+
+// Error returns a string representation of a FallbackIDError.
+func (e FallbackIDError) Error() string {
+	return fmt.Sprintf("fallback ID %d is not one of %d lexeme patterns",
+		e.ID, e.Patterns)
+}
+
+func (e FallbackIDError) implementsError() {}
+
+// GroupNameError is returned when the lexer is created from compiled
+// regular expressions containing a named capturing group whose name
+// conflicts with that of another group, either because the same name
+// has already been used, or because it is a decimal number, like the
+// names of the groups the lexer wraps around each expression.
+type GroupNameError struct {
+	// Index is the index of the expression containing the group.
+	Index int
+	// Name is the name of the group.
+	Name string
+}
+
+func newGroupNameError(index int, name string) Error {
+	return GroupNameError{index, name}
+}
+
+// Error returns a string representation of a GroupNameError.
+func (e GroupNameError) Error() string {
+	return fmt.Sprintf("lexeme pattern %d has conflicting group name %q",
+		e.Index, e.Name)
+}
+
+func (e GroupNameError) implementsError() {}
+
+// InternalMatchError is returned when the combined regular expression
+// of the lexer matches the input, but none of the groups for its
+// lexeme patterns does, so that the lexer fails to find the regex
+// match index. This indicates a bug in the lexer rather than a problem
+// with the input or the patterns.
+type InternalMatchError struct {
+	// Index is the index in the input at which the match was found.
+	Index int
+	// Match is the input which was matched.
+	Match string
+}
+
+func newInternalMatchError(index int, match string) Error {
+	return InternalMatchError{index, match}
+}
+
+// Error returns a string representation of an InternalMatchError.
+func (e InternalMatchError) Error() string {
+	return fmt.Sprintf("failed to find regex match index for %q at %d",
+		e.Match, e.Index)
+}
+
+func (e InternalMatchError) implementsError() {}
+
+// IndentationError is returned when a line of the input is indented
+// less than the line before it, but not to the width of any enclosing
+// level of indentation, if the lexer was created with the
+// WithIndentation option.
+type IndentationError struct {
+	// Index is the index in the input of the first token on the line.
+	Index int
+	// Line is the line of the input, starting at 1.
+	Line int
+	// Width is the width of the indentation of the line.
+	Width int
+}
+
+func newIndentationError(index, line, width int) Error {
+	return IndentationError{index, line, width}
+}
+
+// Error returns a string representation of an IndentationError.
+func (e IndentationError) Error() string {
+	return fmt.Sprintf("indentation of width %d at line %d doesn't match "+
+		"any enclosing level", e.Width, e.Line)
+}
+
+func (e IndentationError) implementsError() {}
+
 // InputError is returned when the lexer cannot read from its input.
 type InputError struct {
-	iErr error
+	// BytesRead is the number of bytes successfully read from the
+	// input before the error.
+	BytesRead int
+	iErr      error
 }
 
-func newInputError(err error) Error {
-	return InputError{err}
+func newInputError(err error, read int) Error {
+	return InputError{read, err}
 }
 
 // Error returns a string representation of an InputError.
 func (e InputError) Error() string {
-	return fmt.Sprintf("couldn't get input: %v", e.iErr)
+	return fmt.Sprintf("couldn't get input after %d bytes: %v",
+		e.BytesRead, e.iErr)
+}
+
+// Unwrap returns the error encountered while reading the input.
+func (e InputError) Unwrap() error {
+	return e.iErr
 }
 
 func (e InputError) implementsError() {}
+
+// NamesError is returned when the lexer is created with a different
+// number of names than lexeme patterns.
+type NamesError struct {
+	// Patterns is the number of lexeme patterns provided.
+	Patterns int
+	// Names is the number of names provided.
+	Names int
+}
+
+func newNamesError(patterns, names int) Error {
+	return NamesError{patterns, names}
+}
+
+// Error returns a string representation of a NamesError.
+func (e NamesError) Error() string {
+	return fmt.Sprintf("got %d names for %d lexeme patterns",
+		e.Names, e.Patterns)
+}
+
+func (e NamesError) implementsError() {}
+
+// ModeError is returned when a modal lexer is created without a
+// default mode, or with actions for, or which enter, a mode which
+// has no lexeme patterns.
+type ModeError struct {
+	// Mode is the name of the undefined mode.
+	Mode string
+}
+
+func newModeError(mode string) Error {
+	return ModeError{mode}
+}
+
+// Error returns a string representation of a ModeError.
+func (e ModeError) Error() string {
+	return fmt.Sprintf("undefined mode %q", e.Mode)
+}
+
+func (e ModeError) implementsError() {}
+
+// ActionsError is returned when a modal lexer is created with a
+// different number of actions than lexeme patterns for a mode.
+type ActionsError struct {
+	// Mode is the name of the mode.
+	Mode string
+	// Patterns is the number of lexeme patterns provided for the mode.
+	Patterns int
+	// Actions is the number of actions provided for the mode.
+	Actions int
+}
+
+func newActionsError(mode string, patterns, actions int) Error {
+	return ActionsError{mode, patterns, actions}
+}
+
+// Error returns a string representation of an ActionsError.
+func (e ActionsError) Error() string {
+	return fmt.Sprintf("got %d actions for %d lexeme patterns in mode %q",
+		e.Actions, e.Patterns, e.Mode)
+}
+
+func (e ActionsError) implementsError() {}
+
+// ContextError is returned when lexing is stopped because its
+// context was cancelled or its deadline passed.
+type ContextError struct {
+	cErr error
+}
+
+func newContextError(err error) Error {
+	return ContextError{err}
+}
+
+// Error returns a string representation of a ContextError.
+func (e ContextError) Error() string {
+	return fmt.Sprintf("lexing stopped: %v", e.cErr)
+}
+
+// Unwrap returns the context error which caused lexing to stop.
+func (e ContextError) Unwrap() error {
+	return e.cErr
+}
+
+func (e ContextError) implementsError() {}
+
+// BinaryDataError is returned by TokenList.UnmarshalBinary when its
+// data is not a valid encoding of a token list.
+type BinaryDataError struct {
+	// Offset is the offset in the data at which decoding failed.
+	Offset int
+}
+
+func newBinaryDataError(offset int) Error {
+	return BinaryDataError{offset}
+}
+
+// Error returns a string representation of a BinaryDataError.
+func (e BinaryDataError) Error() string {
+	return fmt.Sprintf("invalid token list data at byte %d", e.Offset)
+}
+
+func (e BinaryDataError) implementsError() {}
+
+// CallbackError is returned when lexing is stopped because a function
+// called for each token returned an error.
+type CallbackError struct {
+	cErr error
+}
+
+func newCallbackError(err error) Error {
+	return CallbackError{err}
+}
+
+// Error returns a string representation of a CallbackError.
+func (e CallbackError) Error() string {
+	return fmt.Sprintf("lexing stopped by callback: %v", e.cErr)
+}
+
+// Unwrap returns the error returned by the callback.
+func (e CallbackError) Unwrap() error {
+	return e.cErr
+}
+
+func (e CallbackError) implementsError() {}
+
+// InputTooLargeError is returned when the input exceeds the maximum
+// size set with the WithMaxInputBytes option.
+type InputTooLargeError struct {
+	// Limit is the maximum size of the input in bytes.
+	Limit int
+}
+
+func newInputTooLargeError(limit int) Error {
+	return InputTooLargeError{limit}
+}
+
+// Error returns a string representation of an InputTooLargeError.
+func (e InputTooLargeError) Error() string {
+	return fmt.Sprintf("input exceeds maximum size of %d bytes", e.Limit)
+}
+
+func (e InputTooLargeError) implementsError() {}
+
+// NoPatternsError is returned when the lexer is created without any
+// lexeme patterns, since it could then match no input at all.
+type NoPatternsError struct{}
+
+func newNoPatternsError() Error {
+	return NoPatternsError{}
+}
+
+// Error returns a string representation of a NoPatternsError.
+func (e NoPatternsError) Error() string {
+	return "no lexeme patterns provided"
+}
+
+func (e NoPatternsError) implementsError() {}
+
+// PatternError is returned when a lexeme pattern added to a Builder,
+// or checked with Validate, cannot be compiled.
+type PatternError struct {
+	// Index is the index the pattern would have had.
+	Index int
+	// Pattern is the source of the pattern.
+	Pattern string
+	pErr    error
+}
+
+func newPatternError(index int, pattern string, err error) Error {
+	return PatternError{index, pattern, err}
+}
+
+// Error returns a string representation of a PatternError.
+func (e PatternError) Error() string {
+	return fmt.Sprintf("couldn't compile lexeme pattern %d %q: %v",
+		e.Index, e.Pattern, e.pErr)
+}
+
+// Unwrap returns the error from compiling the pattern.
+func (e PatternError) Unwrap() error {
+	return e.pErr
+}
+
+func (e PatternError) implementsError() {}
+
+// ShadowedPatternError is returned by Validate when a lexeme pattern
+// can never be matched, because an earlier pattern is always matched
+// instead.
+type ShadowedPatternError struct {
+	// Index is the index of the pattern which can never be matched,
+	// counting any skip patterns as following the lexeme patterns.
+	Index int
+	// By is the index of the earlier pattern which is matched
+	// instead.
+	By int
+	// Mode is the name of the mode of the patterns, if the lexer was
+	// created with NewModal.
+	Mode string
+}
+
+func newShadowedPatternError(index, by int) Error {
+	return ShadowedPatternError{Index: index, By: by}
+}
+
+// Error returns a string representation of a ShadowedPatternError.
+func (e ShadowedPatternError) Error() string {
+	if e.Mode != "" {
+		return fmt.Sprintf("lexeme pattern %d in mode %q is shadowed by "+
+			"pattern %d", e.Index, e.Mode, e.By)
+	}
+	return fmt.Sprintf("lexeme pattern %d is shadowed by pattern %d",
+		e.Index, e.By)
+}
+
+func (e ShadowedPatternError) implementsError() {}
+
+// StartError is returned when the offset from which LexFrom is asked
+// to start lexing is outside its input.
+type StartError struct {
+	// Start is the offset from which lexing was to start.
+	Start int
+	// Length is the length of the input.
+	Length int
+}
+
+func newStartError(start, length int) Error {
+	return StartError{start, length}
+}
+
+// Error returns a string representation of a StartError.
+func (e StartError) Error() string {
+	return fmt.Sprintf("start offset %d is outside input of %d bytes",
+		e.Start, e.Length)
+}
+
+func (e StartError) implementsError() {}
+
+// StopPatternError is returned when the lexer is created with the
+// WithStopPattern option and an ID which is not that of one of the
+// lexeme patterns.
+type StopPatternError struct {
+	// ID is the ID provided with the option.
+	ID int
+	// Patterns is the number of lexeme patterns provided.
+	Patterns int
+}
+
+func newStopPatternError(id, patterns int) Error {
+	return StopPatternError{id, patterns}
+}
+
+// Error returns a string representation of a StopPatternError.
+func (e StopPatternError) Error() string {
+	return fmt.Sprintf("stop pattern ID %d is not one of %d lexeme patterns",
+		e.ID, e.Patterns)
+}
+
+func (e StopPatternError) implementsError() {}
+
+// TimeoutError is returned when finding which pattern matches at a
+// position in the input takes longer than the timeout set with the
+// WithStepTimeout option.
+type TimeoutError struct {
+	// Index is the index in the input at which the step started.
+	Index int
+	// Timeout is the timeout which was exceeded.
+	Timeout time.Duration
+}
+
+func newTimeoutError(index int, timeout time.Duration) Error {
+	return TimeoutError{index, timeout}
+}
+
+// Error returns a string representation of a TimeoutError.
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("matching at position %d took longer than %v",
+		e.Index, e.Timeout)
+}
+
+func (e TimeoutError) implementsError() {}
+
+// TokenLimitError is returned when the lexer finds more tokens in an
+// input than the maximum set with the WithMaxTokens option.
+type TokenLimitError struct {
+	// Limit is the maximum number of tokens.
+	Limit int
+	// Index is the index in the input of the first token beyond the
+	// limit.
+	Index int
+}
+
+func newTokenLimitError(limit, index int) Error {
+	return TokenLimitError{limit, index}
+}
+
+// Error returns a string representation of a TokenLimitError.
+func (e TokenLimitError) Error() string {
+	return fmt.Sprintf("more than %d tokens found, at position %d",
+		e.Limit, e.Index)
+}
+
+func (e TokenLimitError) implementsError() {}
+
+// TokenTooLongError is returned when the lexer matches a lexeme which
+// is longer than the maximum length set with the WithMaxTokenBytes
+// option.
+type TokenTooLongError struct {
+	// Index is the index in the input where the lexeme was found.
+	Index int
+	// Length is the length of the lexeme in bytes.
+	Length int
+}
+
+func newTokenTooLongError(index, length int) Error {
+	return TokenTooLongError{index, length}
+}
+
+// Error returns a string representation of a TokenTooLongError.
+func (e TokenTooLongError) Error() string {
+	return fmt.Sprintf("lexeme of %d bytes at position %d is too long",
+		e.Length, e.Index)
+}
+
+func (e TokenTooLongError) implementsError() {}
+
+// TrailingInputError is returned when lexing stops before all of the
+// input has been consumed, if the lexer was created with the
+// WithRequireFullMatch option.
+type TrailingInputError struct {
+	// Index is the index in the input of the first input which was
+	// not consumed.
+	Index int
+}
+
+func newTrailingInputError(index int) Error {
+	return TrailingInputError{index}
+}
+
+// Error returns a string representation of a TrailingInputError.
+func (e TrailingInputError) Error() string {
+	return fmt.Sprintf("input at position %d was not consumed", e.Index)
+}
+
+func (e TrailingInputError) implementsError() {}
+
+// UnreadError is returned when a token is unread from a scanner
+// which has no token to unread, either because Next has not yet
+// returned a token, or because the token it last returned has
+// already been unread.
+type UnreadError struct{}
+
+func newUnreadError() Error {
+	return UnreadError{}
+}
+
+// Error returns a string representation of an UnreadError.
+func (e UnreadError) Error() string {
+	return "no token to unread"
+}
+
+func (e UnreadError) implementsError() {}
+
+// UnterminatedCommentError is returned when the input ends inside a
+// comment, if the lexer was created with the WithNestedComment option.
+type UnterminatedCommentError struct {
+	// Index is the index in the input at which the comment starts.
+	Index int
+	// Line is the line of the input, starting at 1, on which the
+	// comment starts.
+	Line int
+	// Column is the position within its line, in runes, starting
+	// at 1, at which the comment starts.
+	Column int
+	// Filename is the name of the input, if one was provided with
+	// the WithFilename option.
+	Filename string
+}
+
+func newUnterminatedCommentError(filename string, index, line,
+	column int) Error {
+	return UnterminatedCommentError{Index: index, Line: line,
+		Column: column, Filename: filename}
+}
+
+// Error returns a string representation of an UnterminatedCommentError.
+func (e UnterminatedCommentError) Error() string {
+	if e.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d: unterminated comment", e.Filename,
+			e.Line, e.Column)
+	}
+	return fmt.Sprintf("unterminated comment at line %d, column %d",
+		e.Line, e.Column)
+}
+
+func (e UnterminatedCommentError) implementsError() {}
+
+// ZeroWidthMatchError is returned when a lexeme pattern matches the
+// empty string at some position in the input, which would produce a
+// token consuming no input, so that lexing could never progress.
+type ZeroWidthMatchError struct {
+	// Pattern is the index of the pattern, counting any skip
+	// patterns as following the lexeme patterns.
+	Pattern int
+	// Index is the index in the input where the pattern matched.
+	Index int
+}
+
+func newZeroWidthMatchError(pattern, index int) Error {
+	return ZeroWidthMatchError{pattern, index}
+}
+
+// Error returns a string representation of a ZeroWidthMatchError.
+func (e ZeroWidthMatchError) Error() string {
+	return fmt.Sprintf("lexeme pattern %d matched the empty string at "+
+		"position %d", e.Pattern, e.Index)
+}
+
+func (e ZeroWidthMatchError) implementsError() {}
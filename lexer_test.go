@@ -1,9 +1,25 @@
 package lexer_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"github.com/paulgriffiths/lexer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode"
 )
 
 func TestLexerGood(t *testing.T) {
@@ -27,18 +43,18 @@ func TestLexerGood(t *testing.T) {
 			},
 			"how 2 fail 435 times with 99 ice creams ten40 dog",
 			lexer.TokenList{
-				lexer.Token{0, "how", 0},
-				lexer.Token{1, "2", 4},
-				lexer.Token{0, "fail", 6},
-				lexer.Token{1, "435", 11},
-				lexer.Token{0, "times", 15},
-				lexer.Token{0, "with", 21},
-				lexer.Token{1, "99", 26},
-				lexer.Token{0, "ice", 29},
-				lexer.Token{0, "creams", 33},
-				lexer.Token{0, "ten", 40},
-				lexer.Token{1, "40", 43},
-				lexer.Token{0, "dog", 46},
+				lexer.Token{ID: 0, Value: "how", Index: 0},
+				lexer.Token{ID: 1, Value: "2", Index: 4},
+				lexer.Token{ID: 0, Value: "fail", Index: 6},
+				lexer.Token{ID: 1, Value: "435", Index: 11},
+				lexer.Token{ID: 0, Value: "times", Index: 15},
+				lexer.Token{ID: 0, Value: "with", Index: 21},
+				lexer.Token{ID: 1, Value: "99", Index: 26},
+				lexer.Token{ID: 0, Value: "ice", Index: 29},
+				lexer.Token{ID: 0, Value: "creams", Index: 33},
+				lexer.Token{ID: 0, Value: "ten", Index: 40},
+				lexer.Token{ID: 1, Value: "40", Index: 43},
+				lexer.Token{ID: 0, Value: "dog", Index: 46},
 			},
 		},
 		{
@@ -57,17 +73,17 @@ func TestLexerGood(t *testing.T) {
 			},
 			"how 2 fail 435 times with 99 ice creams ten40 dog",
 			lexer.TokenList{
-				lexer.Token{0, "how", 0},
-				lexer.Token{1, "2", 4},
-				lexer.Token{0, "fail", 6},
-				lexer.Token{1, "435", 11},
-				lexer.Token{0, "times", 15},
-				lexer.Token{0, "with", 21},
-				lexer.Token{1, "99", 26},
-				lexer.Token{0, "ice", 29},
-				lexer.Token{0, "creams", 33},
-				lexer.Token{2, "ten40", 40},
-				lexer.Token{0, "dog", 46},
+				lexer.Token{ID: 0, Value: "how", Index: 0},
+				lexer.Token{ID: 1, Value: "2", Index: 4},
+				lexer.Token{ID: 0, Value: "fail", Index: 6},
+				lexer.Token{ID: 1, Value: "435", Index: 11},
+				lexer.Token{ID: 0, Value: "times", Index: 15},
+				lexer.Token{ID: 0, Value: "with", Index: 21},
+				lexer.Token{ID: 1, Value: "99", Index: 26},
+				lexer.Token{ID: 0, Value: "ice", Index: 29},
+				lexer.Token{ID: 0, Value: "creams", Index: 33},
+				lexer.Token{ID: 2, Value: "ten40", Index: 40},
+				lexer.Token{ID: 0, Value: "dog", Index: 46},
 			},
 		},
 		{
@@ -84,17 +100,17 @@ func TestLexerGood(t *testing.T) {
 			},
 			"how 2 fail 435 times with 99 ice creams ten40 dog",
 			lexer.TokenList{
-				lexer.Token{0, "how", 0},
-				lexer.Token{2, "2", 4},
-				lexer.Token{0, "fail", 6},
-				lexer.Token{2, "435", 11},
-				lexer.Token{0, "times", 15},
-				lexer.Token{0, "with", 21},
-				lexer.Token{2, "99", 26},
-				lexer.Token{0, "ice", 29},
-				lexer.Token{0, "creams", 33},
-				lexer.Token{0, "ten40", 40},
-				lexer.Token{0, "dog", 46},
+				lexer.Token{ID: 0, Value: "how", Index: 0},
+				lexer.Token{ID: 2, Value: "2", Index: 4},
+				lexer.Token{ID: 0, Value: "fail", Index: 6},
+				lexer.Token{ID: 2, Value: "435", Index: 11},
+				lexer.Token{ID: 0, Value: "times", Index: 15},
+				lexer.Token{ID: 0, Value: "with", Index: 21},
+				lexer.Token{ID: 2, Value: "99", Index: 26},
+				lexer.Token{ID: 0, Value: "ice", Index: 29},
+				lexer.Token{ID: 0, Value: "creams", Index: 33},
+				lexer.Token{ID: 0, Value: "ten40", Index: 40},
+				lexer.Token{ID: 0, Value: "dog", Index: 46},
 			},
 		},
 		{
@@ -108,17 +124,17 @@ func TestLexerGood(t *testing.T) {
 			},
 			"(32 == 47) = (512 == 681)",
 			lexer.TokenList{
-				lexer.Token{3, "(", 0},
-				lexer.Token{0, "32", 1},
-				lexer.Token{2, "==", 4},
-				lexer.Token{0, "47", 7},
-				lexer.Token{4, ")", 9},
-				lexer.Token{1, "=", 11},
-				lexer.Token{3, "(", 13},
-				lexer.Token{0, "512", 14},
-				lexer.Token{2, "==", 18},
-				lexer.Token{0, "681", 21},
-				lexer.Token{4, ")", 24},
+				lexer.Token{ID: 3, Value: "(", Index: 0},
+				lexer.Token{ID: 0, Value: "32", Index: 1},
+				lexer.Token{ID: 2, Value: "==", Index: 4},
+				lexer.Token{ID: 0, Value: "47", Index: 7},
+				lexer.Token{ID: 4, Value: ")", Index: 9},
+				lexer.Token{ID: 1, Value: "=", Index: 11},
+				lexer.Token{ID: 3, Value: "(", Index: 13},
+				lexer.Token{ID: 0, Value: "512", Index: 14},
+				lexer.Token{ID: 2, Value: "==", Index: 18},
+				lexer.Token{ID: 0, Value: "681", Index: 21},
+				lexer.Token{ID: 4, Value: ")", Index: 24},
 			},
 		},
 		{
@@ -138,18 +154,18 @@ func TestLexerGood(t *testing.T) {
 			},
 			"(3 + 4) * (5 / -6)",
 			lexer.TokenList{
-				lexer.Token{5, "(", 0},
-				lexer.Token{0, "3", 1},
-				lexer.Token{1, "+", 3},
-				lexer.Token{0, "4", 5},
-				lexer.Token{6, ")", 6},
-				lexer.Token{3, "*", 8},
-				lexer.Token{5, "(", 10},
-				lexer.Token{0, "5", 11},
-				lexer.Token{4, "/", 13},
-				lexer.Token{2, "-", 15},
-				lexer.Token{0, "6", 16},
-				lexer.Token{6, ")", 17},
+				lexer.Token{ID: 5, Value: "(", Index: 0},
+				lexer.Token{ID: 0, Value: "3", Index: 1},
+				lexer.Token{ID: 1, Value: "+", Index: 3},
+				lexer.Token{ID: 0, Value: "4", Index: 5},
+				lexer.Token{ID: 6, Value: ")", Index: 6},
+				lexer.Token{ID: 3, Value: "*", Index: 8},
+				lexer.Token{ID: 5, Value: "(", Index: 10},
+				lexer.Token{ID: 0, Value: "5", Index: 11},
+				lexer.Token{ID: 4, Value: "/", Index: 13},
+				lexer.Token{ID: 2, Value: "-", Index: 15},
+				lexer.Token{ID: 0, Value: "6", Index: 16},
+				lexer.Token{ID: 6, Value: ")", Index: 17},
 			},
 		},
 		{
@@ -162,12 +178,12 @@ func TestLexerGood(t *testing.T) {
 			},
 			"to be\nor not to be",
 			lexer.TokenList{
-				lexer.Token{0, "to", 0},
-				lexer.Token{1, "be", 3},
-				lexer.Token{2, "or", 6},
-				lexer.Token{3, "not", 9},
-				lexer.Token{0, "to", 13},
-				lexer.Token{1, "be", 16},
+				lexer.Token{ID: 0, Value: "to", Index: 0},
+				lexer.Token{ID: 1, Value: "be", Index: 3},
+				lexer.Token{ID: 2, Value: "or", Index: 6},
+				lexer.Token{ID: 3, Value: "not", Index: 9},
+				lexer.Token{ID: 0, Value: "to", Index: 13},
+				lexer.Token{ID: 1, Value: "be", Index: 16},
 			},
 		},
 		{
@@ -180,13 +196,13 @@ func TestLexerGood(t *testing.T) {
 			},
 			"to be\nor not to be",
 			lexer.TokenList{
-				lexer.Token{0, "to", 0},
-				lexer.Token{1, "be", 3},
-				lexer.Token{4, "\n", 5},
-				lexer.Token{2, "or", 6},
-				lexer.Token{3, "not", 9},
-				lexer.Token{0, "to", 13},
-				lexer.Token{1, "be", 16},
+				lexer.Token{ID: 0, Value: "to", Index: 0},
+				lexer.Token{ID: 1, Value: "be", Index: 3},
+				lexer.Token{ID: 4, Value: "\n", Index: 5},
+				lexer.Token{ID: 2, Value: "or", Index: 6},
+				lexer.Token{ID: 3, Value: "not", Index: 9},
+				lexer.Token{ID: 0, Value: "to", Index: 13},
+				lexer.Token{ID: 1, Value: "be", Index: 16},
 			},
 		},
 		{
@@ -197,12 +213,12 @@ func TestLexerGood(t *testing.T) {
 			},
 			"abab ccc baa aaa cc baaaa",
 			lexer.TokenList{
-				lexer.Token{0, "abab", 0},
-				lexer.Token{1, "ccc", 5},
-				lexer.Token{0, "baa", 9},
-				lexer.Token{0, "aaa", 13},
-				lexer.Token{1, "cc", 17},
-				lexer.Token{0, "baaaa", 20},
+				lexer.Token{ID: 0, Value: "abab", Index: 0},
+				lexer.Token{ID: 1, Value: "ccc", Index: 5},
+				lexer.Token{ID: 0, Value: "baa", Index: 9},
+				lexer.Token{ID: 0, Value: "aaa", Index: 13},
+				lexer.Token{ID: 1, Value: "cc", Index: 17},
+				lexer.Token{ID: 0, Value: "baaaa", Index: 20},
 			},
 		},
 		{
@@ -213,14 +229,14 @@ func TestLexerGood(t *testing.T) {
 			},
 			"frogbittoadbitbittoadfrogbitfragfrogbitbitbit",
 			lexer.TokenList{
-				lexer.Token{0, "frog", 0},
-				lexer.Token{1, "bit", 4},
-				lexer.Token{0, "toad", 7},
-				lexer.Token{1, "bitbit", 11},
-				lexer.Token{0, "toadfrog", 17},
-				lexer.Token{1, "bit", 25},
-				lexer.Token{0, "fragfrog", 28},
-				lexer.Token{1, "bitbitbit", 36},
+				lexer.Token{ID: 0, Value: "frog", Index: 0},
+				lexer.Token{ID: 1, Value: "bit", Index: 4},
+				lexer.Token{ID: 0, Value: "toad", Index: 7},
+				lexer.Token{ID: 1, Value: "bitbit", Index: 11},
+				lexer.Token{ID: 0, Value: "toadfrog", Index: 17},
+				lexer.Token{ID: 1, Value: "bit", Index: 25},
+				lexer.Token{ID: 0, Value: "fragfrog", Index: 28},
+				lexer.Token{ID: 1, Value: "bitbitbit", Index: 36},
 			},
 		},
 		{
@@ -236,38 +252,54 @@ func TestLexerGood(t *testing.T) {
 			},
 			"S : A' | `terminal` | e\nA' : `another`\n",
 			lexer.TokenList{
-				lexer.Token{0, "S", 0},
-				lexer.Token{3, ":", 2},
-				lexer.Token{0, "A'", 4},
-				lexer.Token{2, "|", 7},
-				lexer.Token{1, "`terminal`", 9},
-				lexer.Token{2, "|", 20},
-				lexer.Token{5, "e", 22},
-				lexer.Token{4, "\n", 23},
-				lexer.Token{0, "A'", 24},
-				lexer.Token{3, ":", 27},
-				lexer.Token{1, "`another`", 29},
-				lexer.Token{4, "\n", 38},
+				lexer.Token{ID: 0, Value: "S", Index: 0},
+				lexer.Token{ID: 3, Value: ":", Index: 2},
+				lexer.Token{ID: 0, Value: "A'", Index: 4},
+				lexer.Token{ID: 2, Value: "|", Index: 7},
+				lexer.Token{ID: 1, Value: "`terminal`", Index: 9},
+				lexer.Token{ID: 2, Value: "|", Index: 20},
+				lexer.Token{ID: 5, Value: "e", Index: 22},
+				lexer.Token{ID: 4, Value: "\n", Index: 23},
+				lexer.Token{ID: 0, Value: "A'", Index: 24},
+				lexer.Token{ID: 3, Value: ":", Index: 27},
+				lexer.Token{ID: 1, Value: "`another`", Index: 29},
+				lexer.Token{ID: 4, Value: "\n", Index: 38},
 			},
 		},
 	}
 
-	for n, tc := range testCases {
-		l, err := lexer.New(tc.lexemes)
-		if err != nil {
-			t.Errorf("case %d, couldn't create lexer: %v", n+1, err)
-			continue
-		}
+	// Each case should produce the same tokens regardless of whether
+	// the input is read all at once or incrementally, and regardless
+	// of how small the increment is.
 
-		tokens, err := l.Lex(strings.NewReader(tc.input))
-		if err != nil {
-			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
-			continue
-		}
+	optionSets := [][]lexer.Option{
+		nil,
+		[]lexer.Option{lexer.WithBufferSize(1)},
+		[]lexer.Option{lexer.WithBufferSize(3)},
+		[]lexer.Option{lexer.WithBufferSize(4096)},
+	}
 
-		if !tokens.Equals(tc.tokens) {
-			t.Errorf("case %d, tokens not equals, got %v, want %v",
-				n+1, tokens, tc.tokens)
+	for m, options := range optionSets {
+		for n, tc := range testCases {
+			l, err := lexer.New(tc.lexemes, options...)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't create lexer: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			tokens, err := l.Lex(iotest.OneByteReader(
+				strings.NewReader(tc.input)))
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			if diff := tokens.Diff(tc.tokens); diff != "" {
+				t.Errorf("option set %d, case %d, tokens not equals, %s",
+					m+1, n+1, diff)
+			}
 		}
 	}
 }
@@ -283,6 +315,9 @@ func TestLexerBadRegexp(t *testing.T) {
 		[]string{
 			"(",
 		},
+		[]string{
+			"a)|(b",
+		},
 	}
 
 	for n, tc := range testCases {
@@ -324,3 +359,3145 @@ func TestLexerNoMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestLexerLineColumn(t *testing.T) {
+	testCases := []struct {
+		value  string
+		line   int
+		column int
+	}{
+		{"to", 1, 1},
+		{"be", 1, 4},
+		{"or", 2, 1},
+		{"not", 2, 4},
+		{"to", 4, 3},
+		{"be", 4, 6},
+		{"café", 5, 1},
+		{"to", 5, 6},
+	}
+
+	l, err := lexer.New([]string{"to", "be", "or", "not", "café"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.Lex(strings.NewReader("to be\nor not\n\n  to be\ncafé to"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	if len(tokens) != len(testCases) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(testCases))
+	}
+
+	for n, tc := range testCases {
+		tok := tokens[n]
+		if tok.Value != tc.value || tok.Line != tc.line ||
+			tok.Column != tc.column {
+			t.Errorf("case %d, got %q at %d:%d, want %q at %d:%d", n+1,
+				tok.Value, tok.Line, tok.Column, tc.value, tc.line, tc.column)
+		}
+	}
+}
+
+func TestLexerEnd(t *testing.T) {
+	testCases := []struct {
+		value string
+		index int
+		end   int
+	}{
+		{"naïve", 0, 6},
+		{"42", 7, 9},
+		{"日本", 10, 16},
+	}
+
+	l, err := lexer.New([]string{"[^[:space:][:digit:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.Lex(strings.NewReader("naïve 42 日本"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	if len(tokens) != len(testCases) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(testCases))
+	}
+
+	for n, tc := range testCases {
+		tok := tokens[n]
+		if tok.Value != tc.value || tok.Index != tc.index || tok.End != tc.end {
+			t.Errorf("case %d, got %q at [%d, %d), want %q at [%d, %d)", n+1,
+				tok.Value, tok.Index, tok.End, tc.value, tc.index, tc.end)
+		}
+		if tok.Len() != len(tok.Value) {
+			t.Errorf("case %d, got length %d, want %d", n+1,
+				tok.Len(), len(tok.Value))
+		}
+	}
+}
+
+func TestLexerRuneIndex(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		tokens  lexer.TokenList
+		end     int
+		index   int
+	}{
+		{
+			nil,
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "naïve", Index: 0},
+				lexer.Token{ID: 1, Value: "42", Index: 7},
+				lexer.Token{ID: 0, Value: "日本", Index: 10},
+			},
+			16,
+			17,
+		},
+		{
+			[]lexer.Option{lexer.WithRuneIndex()},
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "naïve", Index: 0},
+				lexer.Token{ID: 1, Value: "42", Index: 6},
+				lexer.Token{ID: 0, Value: "日本", Index: 9},
+			},
+			11,
+			12,
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{"[[:alpha:]ï日本]+", "[[:digit:]]+"},
+			tc.options...)
+		if err != nil {
+			t.Errorf("case %d, couldn't create lexer: %v", n+1, err)
+			continue
+		}
+
+		tokens, err := l.Lex(strings.NewReader("naïve 42 日本"))
+		if err != nil {
+			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(tc.tokens) {
+			t.Errorf("case %d, tokens not equals, got %v, want %v",
+				n+1, tokens, tc.tokens)
+			continue
+		}
+
+		if end := tokens[len(tokens)-1].End; end != tc.end {
+			t.Errorf("case %d, got end %d, want %d", n+1, end, tc.end)
+		}
+
+		_, err = l.Lex(strings.NewReader("naïve 42 日本 ?"))
+		if merr, ok := err.(lexer.MatchError); !ok {
+			t.Errorf("case %d, got error %v, want MatchError", n+1, err)
+		} else if merr.Index != tc.index {
+			t.Errorf("case %d, got error index %d, want %d",
+				n+1, merr.Index, tc.index)
+		}
+	}
+}
+
+func TestLexerChannel(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens lexer.TokenList
+		index  int
+	}{
+		{
+			"abc 123 abc",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "abc", Index: 0},
+				lexer.Token{ID: 1, Value: "123", Index: 4},
+				lexer.Token{ID: 0, Value: "abc", Index: 8},
+			},
+			-1,
+		},
+		{
+			"abc 123 ?",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "abc", Index: 0},
+				lexer.Token{ID: 1, Value: "123", Index: 4},
+			},
+			8,
+		},
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	for n, tc := range testCases {
+		tokens, errs := l.LexChannel(strings.NewReader(tc.input))
+
+		list := lexer.TokenList{}
+		for token := range tokens {
+			list = append(list, token)
+		}
+
+		if !list.Equals(tc.tokens) {
+			t.Errorf("case %d, tokens not equals, got %v, want %v",
+				n+1, list, tc.tokens)
+		}
+
+		err := <-errs
+		if tc.index == -1 {
+			if err != nil {
+				t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+		} else if merr, ok := err.(lexer.MatchError); !ok {
+			t.Errorf("case %d, got error %v, want MatchError", n+1, err)
+		} else if merr.Index != tc.index {
+			t.Errorf("case %d, got %d, want %d", n+1, merr.Index, tc.index)
+		}
+
+		if _, ok := <-errs; ok {
+			t.Errorf("case %d, error channel not closed", n+1)
+		}
+	}
+}
+
+func TestLexerInputError(t *testing.T) {
+	optionSets := [][]lexer.Option{
+		nil,
+		[]lexer.Option{lexer.WithBufferSize(4)},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.New([]string{"[[:alpha:]]+"}, options...)
+		if err != nil {
+			t.Fatalf("couldn't create lexer: %v", err)
+		}
+
+		input := io.MultiReader(strings.NewReader("abc def ghi"),
+			iotest.ErrReader(errors.New("failed")))
+		if _, err := l.Lex(input); err == nil {
+			t.Errorf("case %d, input unexpectedly read", n+1)
+		} else if ierr, ok := err.(lexer.InputError); !ok {
+			t.Errorf("case %d, error of unexpected type", n+1)
+		} else if ierr.BytesRead != 11 {
+			t.Errorf("case %d, got %d bytes read, want 11",
+				n+1, ierr.BytesRead)
+		}
+	}
+}
+
+func TestLexerNamed(t *testing.T) {
+	l, err := lexer.NewNamed(
+		[]string{"[[:alpha:]]+", "[[:digit:]]+", "[\\.,]"},
+		[]string{"Word", "Number", "Punctuation"},
+	)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.Lex(strings.NewReader("20 cats, catch"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := []string{
+		`Token(name=Number, value="20", index=0)`,
+		`Token(name=Word, value="cats", index=3)`,
+		`Token(name=Punctuation, value=",", index=7)`,
+		`Token(name=Word, value="catch", index=9)`,
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+
+	for n, tok := range tokens {
+		if tok.Name != l.Name(tok.ID) {
+			t.Errorf("case %d, got name %q, want %q", n+1,
+				tok.Name, l.Name(tok.ID))
+		}
+		if got := tok.String(); got != want[n] {
+			t.Errorf("case %d, got %s, want %s", n+1, got, want[n])
+		}
+	}
+
+	if name := l.Name(3); name != "" {
+		t.Errorf("got name %q for invalid id, want empty string", name)
+	}
+}
+
+func TestLexerNamedBadNames(t *testing.T) {
+	_, err := lexer.NewNamed([]string{"a", "b"}, []string{"A"})
+	if nerr, ok := err.(lexer.NamesError); !ok {
+		t.Errorf("got error %v, want NamesError", err)
+	} else if nerr.Patterns != 2 || nerr.Names != 1 {
+		t.Errorf("got %d names for %d patterns, want 1 for 2",
+			nerr.Names, nerr.Patterns)
+	}
+}
+
+func TestLexerSkipPatterns(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens lexer.TokenList
+	}{
+		{
+			// A comment immediately before the end of the input.
+
+			"a = b // comment",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+				lexer.Token{ID: 2, Value: "=", Index: 2},
+				lexer.Token{ID: 0, Value: "b", Index: 4},
+			},
+		},
+		{
+			// Comments containing input which would otherwise match
+			// lexeme patterns, including the doubled slash which would
+			// otherwise match as two division operators.
+
+			"a /= b //= c\nd / /* e = f */ g",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+				lexer.Token{ID: 3, Value: "/=", Index: 2},
+				lexer.Token{ID: 0, Value: "b", Index: 5},
+				lexer.Token{ID: 0, Value: "d", Index: 13},
+				lexer.Token{ID: 1, Value: "/", Index: 15},
+				lexer.Token{ID: 0, Value: "g", Index: 29},
+			},
+		},
+		{
+			// Only comments.
+
+			"/* a */ // b",
+			lexer.TokenList{},
+		},
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "/", "=", "/="},
+		lexer.WithSkipPatterns("//[^\n]*", "/\\*([^*]|\\*[^/])*\\*/"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	for n, tc := range testCases {
+		tokens, err := l.Lex(strings.NewReader(tc.input))
+		if err != nil {
+			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(tc.tokens) {
+			t.Errorf("case %d, tokens not equals, got %v, want %v",
+				n+1, tokens, tc.tokens)
+		}
+	}
+}
+
+func TestLexerWhitespace(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		isSpace func(rune) bool
+		input   string
+		tokens  lexer.TokenList
+	}{
+		{
+			// Commas and semicolons may be skipped as separators.
+
+			[]string{"[[:digit:]]+"},
+			func(r rune) bool {
+				return r == ',' || r == ';' || unicode.IsSpace(r)
+			},
+			"1,2, 3;4",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "1", Index: 0},
+				lexer.Token{ID: 0, Value: "2", Index: 2},
+				lexer.Token{ID: 0, Value: "3", Index: 5},
+				lexer.Token{ID: 0, Value: "4", Index: 7},
+			},
+		},
+		{
+			// Spaces may be preserved for a pattern to match, while
+			// tabs are still skipped.
+
+			[]string{"[[:alpha:]]+", " +"},
+			func(r rune) bool {
+				return r == '\t'
+			},
+			"a  b\tc",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+				lexer.Token{ID: 1, Value: "  ", Index: 1},
+				lexer.Token{ID: 0, Value: "b", Index: 3},
+				lexer.Token{ID: 0, Value: "c", Index: 5},
+			},
+		},
+		{
+			// The newline character is still not skipped when it is
+			// one of the lexeme patterns.
+
+			[]string{"[[:digit:]]+", "\n"},
+			func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			},
+			"1,2\n3",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "1", Index: 0},
+				lexer.Token{ID: 0, Value: "2", Index: 2},
+				lexer.Token{ID: 1, Value: "\n", Index: 3},
+				lexer.Token{ID: 0, Value: "3", Index: 4},
+			},
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, lexer.WithWhitespace(tc.isSpace))
+		if err != nil {
+			t.Errorf("case %d, couldn't create lexer: %v", n+1, err)
+			continue
+		}
+
+		tokens, err := l.Lex(strings.NewReader(tc.input))
+		if err != nil {
+			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(tc.tokens) {
+			t.Errorf("case %d, tokens not equals, got %v, want %v",
+				n+1, tokens, tc.tokens)
+		}
+	}
+}
+
+func TestLexerUnicodeWhitespace(t *testing.T) {
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithBufferSize(1)},
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a", Index: 0},
+		lexer.Token{ID: 0, Value: "b", Index: 3},
+		lexer.Token{ID: 0, Value: "c", Index: 7},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.New([]string{"[[:alpha:]]+"}, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		// A non-breaking space and an ideographic space, which are
+		// two and three bytes long, read one byte at a time.
+
+		tokens, err := l.Lex(iotest.OneByteReader(
+			strings.NewReader("a\u00a0b\u3000c")))
+		if err != nil {
+			t.Errorf("option set %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(want) {
+			t.Errorf("option set %d, got %v, want %v", n+1, tokens, want)
+		}
+	}
+}
+
+func TestLexerCaseInsensitive(t *testing.T) {
+	l, err := lexer.New([]string{"select", "from", "[[:alpha:]]+", ","},
+		lexer.WithCaseInsensitive())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.Lex(strings.NewReader("SELECT a, Bc FROM select"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "SELECT", Index: 0},
+		lexer.Token{ID: 2, Value: "a", Index: 7},
+		lexer.Token{ID: 3, Value: ",", Index: 8},
+		lexer.Token{ID: 2, Value: "Bc", Index: 10},
+		lexer.Token{ID: 1, Value: "FROM", Index: 13},
+		lexer.Token{ID: 0, Value: "select", Index: 18},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("tokens not equals, got %v, want %v", tokens, want)
+	}
+}
+
+func TestLexerRecover(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, errs, err := l.LexRecover(strings.NewReader("?ab 12%%cd ☃ ef!"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "ab", Index: 1},
+		lexer.Token{ID: 1, Value: "12", Index: 4},
+		lexer.Token{ID: 0, Value: "cd", Index: 8},
+		lexer.Token{ID: 0, Value: "ef", Index: 15},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("tokens not equals, got %v, want %v", tokens, want)
+	}
+
+	indices := []int{0, 6, 11, 17}
+	if len(errs) != len(indices) {
+		t.Fatalf("got %d errors, want %d", len(errs), len(indices))
+	}
+	for n, index := range indices {
+		if errs[n].Index != index {
+			t.Errorf("case %d, got %d, want %d", n+1, errs[n].Index, index)
+		}
+	}
+}
+
+func TestLexerStringAndBytes(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc", Index: 0},
+		lexer.Token{ID: 1, Value: "123", Index: 4},
+	}
+
+	if tokens, err := l.LexString("abc 123"); err != nil {
+		t.Errorf("couldn't get tokens from string: %v", err)
+	} else if !tokens.Equals(want) {
+		t.Errorf("string tokens not equals, got %v, want %v", tokens, want)
+	}
+
+	if tokens, err := l.LexBytes([]byte("abc 123")); err != nil {
+		t.Errorf("couldn't get tokens from bytes: %v", err)
+	} else if !tokens.Equals(want) {
+		t.Errorf("bytes tokens not equals, got %v, want %v", tokens, want)
+	}
+
+	// The values of the tokens don't share storage with the input.
+
+	for _, options := range [][]lexer.Option{nil, {lexer.WithInterning()}} {
+		l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+			options...)
+		if err != nil {
+			t.Fatalf("couldn't create lexer: %v", err)
+		}
+
+		input := []byte("abc 123")
+		tokens, err := l.LexBytes(input)
+		if err != nil {
+			t.Fatalf("couldn't get tokens from bytes: %v", err)
+		}
+		copy(input, "xyz 789")
+		if !tokens.Equals(want) {
+			t.Errorf("tokens changed with input, got %v, want %v",
+				tokens, want)
+		}
+	}
+
+	if _, err := l.LexBytes([]byte("abc ?")); err == nil {
+		t.Errorf("bytes unexpectedly matched")
+	} else if merr, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("error of unexpected type")
+	} else if merr.Index != 4 {
+		t.Errorf("got %d, want %d", merr.Index, 4)
+	}
+}
+
+func TestLexerContext(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexContext(context.Background(),
+		strings.NewReader("abc def"))
+	if err != nil {
+		t.Errorf("couldn't get tokens: %v", err)
+	} else if tokens.Len() != 2 {
+		t.Errorf("got %d tokens, want %d", tokens.Len(), 2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := l.LexContext(ctx, strings.NewReader("abc def")); err == nil {
+		t.Errorf("lexing unexpectedly completed")
+	} else if _, ok := err.(lexer.ContextError); !ok {
+		t.Errorf("error of unexpected type")
+	} else if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	// Input which yields no tokens is stopped too.
+
+	for _, input := range []string{"", "   "} {
+		_, err := l.LexContext(ctx, strings.NewReader(input))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v for %q, want %v", err, input, context.Canceled)
+		}
+	}
+
+	l, err = lexer.New([]string{"[[:alpha:]]+"},
+		lexer.WithSkipPatterns("#[^\n]*"), lexer.WithBufferSize(4))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	input := &cancelReader{reader: strings.NewReader("# a\n# b\n# c\n"),
+		cancel: cancel}
+	tokens, err = l.LexContext(ctx, input)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v for skipped input, want %v", err, context.Canceled)
+	} else if len(tokens) != 0 {
+		t.Errorf("got %v for skipped input, want none", tokens)
+	}
+}
+
+// cancelReader reads from a reader, calling cancel after the first
+// read.
+type cancelReader struct {
+	reader io.Reader
+	cancel func()
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	defer r.cancel()
+	return r.reader.Read(p)
+}
+
+func TestLexerConcurrent(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	inputs := []string{"abc 123", "4 d 56 ef", "ghi", "7 8 9"}
+	counts := []int{2, 4, 1, 3}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for n := range inputs {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					tokens, err := l.LexString(inputs[n])
+					if err != nil {
+						t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+						return
+					}
+					if tokens.Len() != counts[n] {
+						t.Errorf("case %d, got %d tokens, want %d",
+							n+1, tokens.Len(), counts[n])
+						return
+					}
+				}
+			}(n)
+		}
+	}
+	wg.Wait()
+}
+
+func TestLexerNamedGroups(t *testing.T) {
+	l, err := lexer.New([]string{
+		"(?P<year>[[:digit:]]{4})-(?P<month>[[:digit:]]{2})",
+		"(?P<0>[[:alpha:]]+)",
+		"[[:digit:]]+",
+	})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("2018-06 june 42")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "2018-06", Index: 0},
+		lexer.Token{ID: 1, Value: "june", Index: 8},
+		lexer.Token{ID: 2, Value: "42", Index: 13},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("tokens not equals, got %v, want %v", tokens, want)
+	}
+}
+
+func TestLexerNoInternalMatchError(t *testing.T) {
+
+	// Groups named or numbered like those the lexer wraps around each
+	// pattern, and optional or nested groups which may not take part
+	// in a match, must not stop the lexer identifying which pattern
+	// matched.
+
+	lexemes := []string{
+		"(?P<1>a)(?P<0>b)?",
+		"((c)|(?P<2>d))+",
+		"(?:(e)|f)(g)?",
+		"(?P<x>[[:digit:]])(?P<x>[[:digit:]])?",
+	}
+
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithBufferSize(1)},
+		{lexer.WithFirstMatch()},
+	}
+
+	for m, options := range optionSets {
+		l, err := lexer.New(lexemes, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", m+1, err)
+		}
+
+		for n, input := range []string{"ab a cdcd dc", "eg f fg 1 12"} {
+			tokens, err := l.LexString(input)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+			} else if got := tokens.Join(" "); got != input {
+				t.Errorf("option set %d, case %d, got %q, want %q",
+					m+1, n+1, got, input)
+			}
+		}
+	}
+}
+
+func TestLexerMatchErrorContext(t *testing.T) {
+	testCases := []struct {
+		input   string
+		line    int
+		column  int
+		context string
+		message string
+	}{
+		{
+			"abc\nde fg\nh %!! ij",
+			3, 3, "%!! ij",
+			`couldn't match input at line 3, column 3: "%!! ij"`,
+		},
+		{
+			"été ☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃",
+			1, 5, "☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃",
+			`couldn't match input at line 1, column 5: "☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃☃"`,
+		},
+	}
+
+	for _, size := range []int{0, 1} {
+		l, err := lexer.New([]string{"[[:alpha:]é]+"},
+			lexer.WithBufferSize(size))
+		if err != nil {
+			t.Fatalf("couldn't create lexer: %v", err)
+		}
+
+		for n, tc := range testCases {
+			_, err := l.LexString(tc.input)
+			checkMatchErrorContext(t, n+1, err, tc.line, tc.column,
+				tc.context, tc.message)
+
+			// The scanner reads the input as it goes, and finds the
+			// same context.
+
+			scanner := l.Scan(strings.NewReader(tc.input))
+			var serr error
+			for serr == nil {
+				_, serr = scanner.Next()
+			}
+			checkMatchErrorContext(t, n+1, serr, tc.line, tc.column,
+				tc.context, tc.message)
+		}
+	}
+}
+
+// checkMatchErrorContext checks that an error is a MatchError at the
+// provided position, with the provided context and message.
+func checkMatchErrorContext(t *testing.T, n int, err error, line,
+	column int, context, message string) {
+	t.Helper()
+	merr, ok := err.(lexer.MatchError)
+	if !ok {
+		t.Errorf("case %d, got error %v, want MatchError", n, err)
+		return
+	}
+
+	if merr.Line != line || merr.Column != column {
+		t.Errorf("case %d, got %d:%d, want %d:%d", n, merr.Line,
+			merr.Column, line, column)
+	}
+	if merr.Context != context {
+		t.Errorf("case %d, got context %q, want %q", n, merr.Context,
+			context)
+	}
+	if merr.Error() != message {
+		t.Errorf("case %d, got message %q, want %q", n, merr.Error(),
+			message)
+	}
+}
+
+func TestLexerEOFToken(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens lexer.TokenList
+	}{
+		{
+			"abc 123 ",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "abc", Index: 0},
+				lexer.Token{ID: 1, Value: "123", Index: 4},
+				lexer.Token{ID: lexer.EOF, Value: "", Index: 8},
+			},
+		},
+		{
+			"",
+			lexer.TokenList{
+				lexer.Token{ID: lexer.EOF, Value: "", Index: 0},
+			},
+		},
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	for n, tc := range testCases {
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(tc.tokens) {
+			t.Errorf("case %d, tokens not equals, got %v, want %v",
+				n+1, tokens, tc.tokens)
+		}
+	}
+}
+
+func TestLexerTrivia(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		input   string
+		tokens  lexer.TokenList
+	}{
+		{
+			[]string{"[[:alpha:]]+"},
+			"  ab \t\n\n cd\n",
+			lexer.TokenList{
+				lexer.Token{ID: lexer.Whitespace, Value: "  ", Index: 0},
+				lexer.Token{ID: 0, Value: "ab", Index: 2},
+				lexer.Token{ID: lexer.Whitespace, Value: " \t\n\n ", Index: 4},
+				lexer.Token{ID: 0, Value: "cd", Index: 9},
+				lexer.Token{ID: lexer.Whitespace, Value: "\n", Index: 11},
+			},
+		},
+		{
+			// A declared newline lexeme still takes precedence.
+
+			[]string{"[[:alpha:]]+", "\n"},
+			"ab \n\n cd",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "ab", Index: 0},
+				lexer.Token{ID: lexer.Whitespace, Value: " ", Index: 2},
+				lexer.Token{ID: 1, Value: "\n", Index: 3},
+				lexer.Token{ID: 1, Value: "\n", Index: 4},
+				lexer.Token{ID: lexer.Whitespace, Value: " ", Index: 5},
+				lexer.Token{ID: 0, Value: "cd", Index: 6},
+			},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		[]lexer.Option{lexer.WithTrivia()},
+		[]lexer.Option{lexer.WithTrivia(), lexer.WithBufferSize(1)},
+	}
+
+	for m, options := range optionSets {
+		for n, tc := range testCases {
+			l, err := lexer.New(tc.lexemes, options...)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't create lexer: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			tokens, err := l.Lex(strings.NewReader(tc.input))
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			if diff := tokens.Diff(tc.tokens); diff != "" {
+				t.Errorf("option set %d, case %d, tokens not equals, %s",
+					m+1, n+1, diff)
+			}
+		}
+	}
+}
+
+func TestLexerNormalizeNewlines(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		tokens  lexer.TokenList
+		lines   []int
+	}{
+		{
+			[]string{"[[:alpha:]]+", "\n"},
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "ab", Index: 0},
+				lexer.Token{ID: 1, Value: "\n", Index: 2},
+				lexer.Token{ID: 0, Value: "cd", Index: 4},
+				lexer.Token{ID: 1, Value: "\n", Index: 6},
+				lexer.Token{ID: 0, Value: "ef", Index: 7},
+				lexer.Token{ID: 1, Value: "\n", Index: 10},
+				lexer.Token{ID: 1, Value: "\n", Index: 11},
+			},
+			[]int{1, 1, 2, 2, 3, 3, 4},
+		},
+		{
+			[]string{"[[:alpha:]]+"},
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "ab", Index: 0},
+				lexer.Token{ID: 0, Value: "cd", Index: 4},
+				lexer.Token{ID: 0, Value: "ef", Index: 7},
+			},
+			[]int{1, 2, 3},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		[]lexer.Option{lexer.WithNormalizeNewlines()},
+		[]lexer.Option{lexer.WithNormalizeNewlines(), lexer.WithBufferSize(1)},
+	}
+
+	for m, options := range optionSets {
+		for n, tc := range testCases {
+			l, err := lexer.New(tc.lexemes, options...)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't create lexer: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			tokens, err := l.Lex(strings.NewReader("ab\r\ncd\ref \r\r"))
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			if !tokens.Equals(tc.tokens) {
+				t.Errorf("option set %d, case %d, tokens not equals, "+
+					"got %v, want %v", m+1, n+1, tokens, tc.tokens)
+				continue
+			}
+
+			for i, token := range tokens {
+				if token.Line != tc.lines[i] {
+					t.Errorf("option set %d, case %d, token %d, "+
+						"got line %d, want %d", m+1, n+1, i+1,
+						token.Line, tc.lines[i])
+				}
+			}
+		}
+	}
+}
+
+func TestLexerFunc(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	var values []string
+	if err := l.LexFunc(strings.NewReader("ab 12 cd"),
+		func(token lexer.Token) error {
+			values = append(values, token.Value)
+			return nil
+		}); err != nil {
+		t.Errorf("couldn't get tokens: %v", err)
+	} else if strings.Join(values, " ") != "ab 12 cd" {
+		t.Errorf("got values %q, want %q", values, "ab 12 cd")
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = l.LexFunc(strings.NewReader("ab 12 cd ?"),
+		func(token lexer.Token) error {
+			count++
+			if token.ID == 1 {
+				return stop
+			}
+			return nil
+		})
+	if _, ok := err.(lexer.CallbackError); !ok {
+		t.Errorf("got error %v, want CallbackError", err)
+	} else if !errors.Is(err, stop) {
+		t.Errorf("got error %v, want %v", err, stop)
+	}
+	if count != 2 {
+		t.Errorf("got %d calls, want %d", count, 2)
+	}
+}
+
+func TestLexerFirstMatch(t *testing.T) {
+	l, err := lexer.New([]string{
+		"[[:alpha:]]+",
+		"[[:digit:]]+",
+		"[[:alpha:]][[:alnum:]]+",
+		"=",
+		"==",
+	}, lexer.WithFirstMatch())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("ten40 == 7")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "ten", Index: 0},
+		lexer.Token{ID: 1, Value: "40", Index: 3},
+		lexer.Token{ID: 3, Value: "=", Index: 6},
+		lexer.Token{ID: 3, Value: "=", Index: 7},
+		lexer.Token{ID: 1, Value: "7", Index: 9},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("tokens not equals, got %v, want %v", tokens, want)
+	}
+}
+
+func TestLexerMaxInputBytes(t *testing.T) {
+	testCases := []struct {
+		input    string
+		tooLarge bool
+	}{
+		{"abc def", false},
+		{"abc defg", false},
+		{"abc defgh", true},
+		{"abc defgh ijklmnop", true},
+	}
+
+	optionSets := [][]lexer.Option{
+		[]lexer.Option{lexer.WithMaxInputBytes(8)},
+		[]lexer.Option{lexer.WithMaxInputBytes(8), lexer.WithBufferSize(3)},
+	}
+
+	for m, options := range optionSets {
+		l, err := lexer.New([]string{"[[:alpha:]]+"}, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", m+1, err)
+		}
+
+		for n, tc := range testCases {
+			errs := []error{}
+			_, err := l.LexString(tc.input)
+			errs = append(errs, err)
+			_, err = l.LexBytes([]byte(tc.input))
+			errs = append(errs, err)
+
+			for _, err := range errs {
+				if !tc.tooLarge && err != nil {
+					t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+						m+1, n+1, err)
+				} else if tc.tooLarge {
+					if terr, ok := err.(lexer.InputTooLargeError); !ok {
+						t.Errorf("option set %d, case %d, got error %v, "+
+							"want InputTooLargeError", m+1, n+1, err)
+					} else if terr.Limit != 8 {
+						t.Errorf("option set %d, case %d, got limit %d, "+
+							"want %d", m+1, n+1, terr.Limit, 8)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLexerMaxTokenBytes(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"}, lexer.WithMaxTokenBytes(4))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if _, err := l.LexString("abcd ef ghij"); err != nil {
+		t.Errorf("couldn't get tokens: %v", err)
+	}
+
+	_, err = l.LexString("abcd efghi j")
+	if terr, ok := err.(lexer.TokenTooLongError); !ok {
+		t.Errorf("got error %v, want TokenTooLongError", err)
+	} else if terr.Index != 5 || terr.Length != 5 {
+		t.Errorf("got %d bytes at %d, want %d bytes at %d",
+			terr.Length, terr.Index, 5, 5)
+	}
+}
+
+func TestLexerMaxTokens(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"}, lexer.WithMaxTokens(3))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if _, err := l.LexString("ab c de"); err != nil {
+		t.Errorf("couldn't get tokens: %v", err)
+	}
+
+	tokens, err := l.LexString("ab c de f g")
+	if terr, ok := err.(lexer.TokenLimitError); !ok {
+		t.Errorf("got error %v, want TokenLimitError", err)
+	} else if terr.Limit != 3 || terr.Index != 8 {
+		t.Errorf("got limit %d at %d, want %d at %d",
+			terr.Limit, terr.Index, 3, 8)
+	}
+	if len(tokens) != 3 {
+		t.Errorf("got %d tokens with error, want 3", len(tokens))
+	}
+}
+
+func TestLexerFilename(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"},
+		lexer.WithFilename("foo.gram"), lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if name := l.Filename(); name != "foo.gram" {
+		t.Errorf("got filename %q, want %q", name, "foo.gram")
+	}
+
+	_, err = l.LexString("abc\nde ?")
+	if merr, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("got error %v, want MatchError", err)
+	} else if merr.Filename != "foo.gram" {
+		t.Errorf("got filename %q, want %q", merr.Filename, "foo.gram")
+	}
+
+	want := `foo.gram:2:4: couldn't match input: "?"`
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+}
+
+func BenchmarkLexLargeInput(b *testing.B) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+", `\+`,
+		`\(`, `\)`, "\n"})
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte(strings.Repeat("alpha + (beta + 42) + gamma\n", 4096))
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := l.LexBytes(input); err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+	}
+}
+
+func TestLexerPartialResults(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc", Index: 0},
+		lexer.Token{ID: 1, Value: "123", Index: 4},
+	}
+
+	input := "abc 123 ? def"
+	results := []func() (lexer.TokenList, lexer.Error){
+		func() (lexer.TokenList, lexer.Error) {
+			return l.Lex(strings.NewReader(input))
+		},
+		func() (lexer.TokenList, lexer.Error) {
+			return l.LexString(input)
+		},
+		func() (lexer.TokenList, lexer.Error) {
+			return l.LexBytes([]byte(input))
+		},
+	}
+
+	for n, result := range results {
+		tokens, err := result()
+		if _, ok := err.(lexer.MatchError); !ok {
+			t.Errorf("case %d, got error %v, want MatchError", n+1, err)
+		}
+		if !tokens.Equals(want) {
+			t.Errorf("case %d, got %v, want %v", n+1, tokens, want)
+		}
+	}
+}
+
+func TestLexerRequireFullMatch(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens int
+		match  bool
+	}{
+		{"abc 123  \n\t", 2, true},
+		{"abc 123 # trailing comment", 2, true},
+		{"# only a comment\n", 0, true},
+		{"abc 123 ?", 2, false},
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithSkipPatterns("#[^\n]*"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	strict, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithSkipPatterns("#[^\n]*"), lexer.WithRequireFullMatch())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	for n, tc := range testCases {
+		results := []func() (lexer.TokenList, lexer.Error){
+			func() (lexer.TokenList, lexer.Error) {
+				return l.LexComplete(strings.NewReader(tc.input))
+			},
+			func() (lexer.TokenList, lexer.Error) {
+				return strict.LexString(tc.input)
+			},
+		}
+
+		for i, result := range results {
+			tokens, err := result()
+			if tc.match && err != nil {
+				t.Errorf("case %d, result %d, couldn't get tokens: %v",
+					n+1, i+1, err)
+			} else if _, ok := err.(lexer.MatchError); !tc.match && !ok {
+				t.Errorf("case %d, result %d, got error %v, want MatchError",
+					n+1, i+1, err)
+			}
+			if len(tokens) != tc.tokens {
+				t.Errorf("case %d, result %d, got %d tokens, want %d",
+					n+1, i+1, len(tokens), tc.tokens)
+			}
+		}
+	}
+}
+
+func TestLexerMulti(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	// A token can't span two inputs, even without whitespace between.
+
+	lists, err := l.LexMulti(strings.NewReader("abc 12"),
+		strings.NewReader("34 de"), strings.NewReader("f"))
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := []lexer.TokenList{
+		{
+			lexer.Token{ID: 0, Value: "abc", Index: 0},
+			lexer.Token{ID: 1, Value: "12", Index: 4},
+		},
+		{
+			lexer.Token{ID: 1, Value: "34", Index: 0},
+			lexer.Token{ID: 0, Value: "de", Index: 3},
+		},
+		{
+			lexer.Token{ID: 0, Value: "f", Index: 0},
+			lexer.Token{ID: lexer.EOF, Index: 1},
+		},
+	}
+	if len(lists) != len(want) {
+		t.Fatalf("got %d lists, want %d", len(lists), len(want))
+	}
+	for i, list := range lists {
+		if !list.Equals(want[i]) {
+			t.Errorf("source %d, got %v, want %v", i, list, want[i])
+		}
+	}
+
+	lists, err = l.LexMulti(strings.NewReader("abc"),
+		strings.NewReader("\n12 ?"), strings.NewReader("def"))
+	if merr, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("got error %v, want MatchError", err)
+	} else if merr.Source != 1 || merr.Line != 2 || merr.Column != 4 {
+		t.Errorf("got error in source %d at %d:%d, want source 1 at 2:4",
+			merr.Source, merr.Line, merr.Column)
+	}
+	if len(lists) != 2 || len(lists[0]) != 1 || len(lists[1]) != 1 {
+		t.Errorf("got %v, want one token from each of two sources", lists)
+	}
+}
+
+func TestLexerWith(t *testing.T) {
+	base, err := lexer.NewNamed([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		[]string{"Word", "Number"}, lexer.WithSkipPatterns("#[^\n]*"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	derived, err := base.With(`\+`, "-")
+	if err != nil {
+		t.Fatalf("couldn't derive lexer: %v", err)
+	}
+
+	tokens, err := derived.LexString("a + 1 - b # comment")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a", Index: 0},
+		lexer.Token{ID: 2, Value: "+", Index: 2},
+		lexer.Token{ID: 1, Value: "1", Index: 4},
+		lexer.Token{ID: 3, Value: "-", Index: 6},
+		lexer.Token{ID: 0, Value: "b", Index: 8},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+	if tokens[0].Name != "Word" || tokens[1].Name != "" {
+		t.Errorf("got names %q and %q, want %q and %q",
+			tokens[0].Name, tokens[1].Name, "Word", "")
+	}
+
+	// The original lexer is unchanged.
+
+	if _, err := base.LexString("a + 1"); err == nil {
+		t.Errorf("got no error from original lexer, want MatchError")
+	}
+
+	if _, err := base.With("[[:alpha:]"); err == nil {
+		t.Errorf("got no error for invalid pattern, want RegexError")
+	} else if _, ok := err.(lexer.RegexError); !ok {
+		t.Errorf("got error %v, want RegexError", err)
+	}
+}
+
+func TestLexerWithout(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		ids     []int
+		input   string
+		want    lexer.TokenList
+	}{
+		{
+			[]string{"async", "[[:alpha:]]+"},
+			[]int{0},
+			"async x",
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "async", Index: 0},
+				lexer.Token{ID: 1, Value: "x", Index: 6},
+			},
+		},
+		{
+			[]string{"<=", "<", "="},
+			[]int{0, 7, -1},
+			"<= <",
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "<", Index: 0},
+				lexer.Token{ID: 2, Value: "=", Index: 1},
+				lexer.Token{ID: 1, Value: "<", Index: 3},
+			},
+		},
+		{
+			[]string{"[[:alpha:]]+", "\n"},
+			[]int{1},
+			"a\nb",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+				lexer.Token{ID: 0, Value: "b", Index: 2},
+			},
+		},
+	}
+
+	for n, tc := range testCases {
+		base, err := lexer.New(tc.lexemes)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		l, err := base.Without(tc.ids...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't derive lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Errorf("case %d, couldn't lex input: %v", n+1, err)
+		} else if !tokens.Equals(tc.want) {
+			t.Errorf("case %d, got %v, want %v", n+1, tokens, tc.want)
+		}
+
+		// The original lexer is unchanged.
+
+		if tokens, err := base.LexString(tc.input); err != nil {
+			t.Errorf("case %d, couldn't lex input: %v", n+1, err)
+		} else if tokens[0].ID != 0 {
+			t.Errorf("case %d, got ID %d from original lexer, want 0",
+				n+1, tokens[0].ID)
+		}
+	}
+}
+
+func TestLexerPattern(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		options []lexer.Option
+		want    string
+	}{
+		{
+			[]string{"if", "[[:alpha:]]+"},
+			nil,
+			`^(?:(?P<0>^if)|(?P<1>^[[:alpha:]]+))`,
+		},
+		{
+			[]string{"(a)(b)", `\+`},
+			[]lexer.Option{lexer.WithSkipPatterns("#.*")},
+			`^(?:(?P<0>^(a)(b))|(?P<1>^\+)|(?P<2>^#.*))`,
+		},
+		{
+			[]string{"select"},
+			[]lexer.Option{lexer.WithCaseInsensitive()},
+			`(?i)^(?:(?P<0>^select))`,
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		if got := l.Pattern(); got != tc.want {
+			t.Errorf("case %d, got %s, want %s", n+1, got, tc.want)
+		}
+	}
+}
+
+func TestLexerCandidates(t *testing.T) {
+	l, err := lexer.New([]string{"if", "[[:alpha:]]+", "[[:alnum:]]+",
+		"=", "==", "\n"}, lexer.WithSkipPatterns("i"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte("if x == 1\n")
+
+	testCases := []struct {
+		at   int
+		want []int
+	}{
+		{0, []int{0, 1, 2}},
+		{1, []int{1, 2}},
+		{2, nil},
+		{5, []int{3, 4}},
+		{8, []int{2}},
+		{9, []int{5}},
+		{10, nil},
+		{11, nil},
+		{-1, nil},
+	}
+
+	for n, tc := range testCases {
+		got := l.Candidates(input, tc.at)
+		if len(got) != len(tc.want) {
+			t.Errorf("case %d, got %v, want %v", n+1, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("case %d, got %v, want %v", n+1, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLexerErrorToken(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens lexer.TokenList
+	}{
+		{
+			// Unmatched input runs up to whitespace, or to input at
+			// which a pattern matches.
+
+			"abc ?! 12 ??x?",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "abc", Index: 0},
+				lexer.Token{ID: lexer.Unmatched, Value: "?!", Index: 4},
+				lexer.Token{ID: 1, Value: "12", Index: 7},
+				lexer.Token{ID: lexer.Unmatched, Value: "??", Index: 10},
+				lexer.Token{ID: 0, Value: "x", Index: 12},
+				lexer.Token{ID: lexer.Unmatched, Value: "?", Index: 13},
+			},
+		},
+		{
+			// Unmatched multi-byte runes are kept whole.
+
+			"é€1",
+			lexer.TokenList{
+				lexer.Token{ID: lexer.Unmatched, Value: "é€", Index: 0},
+				lexer.Token{ID: 1, Value: "1", Index: 5},
+			},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		{lexer.WithErrorToken()},
+		{lexer.WithErrorToken(), lexer.WithBufferSize(1)},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+			options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		for i, tc := range testCases {
+			tokens, err := l.Lex(iotest.OneByteReader(
+				strings.NewReader(tc.input)))
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					n+1, i+1, err)
+				continue
+			}
+
+			if !tokens.Equals(tc.tokens) {
+				t.Errorf("option set %d, case %d, got %v, want %v",
+					n+1, i+1, tokens, tc.tokens)
+			}
+		}
+	}
+}
+
+func TestLexerFallbackID(t *testing.T) {
+	testCases := []struct {
+		input  string
+		tokens lexer.TokenList
+	}{
+		{
+			"abc ?! 12",
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "abc", Index: 0},
+				lexer.Token{ID: 2, Value: "?", Index: 4},
+				lexer.Token{ID: 2, Value: "!", Index: 5},
+				lexer.Token{ID: 1, Value: "12", Index: 7},
+			},
+		},
+		{
+			// Unmatched multi-byte runes are kept whole.
+
+			"é€1",
+			lexer.TokenList{
+				lexer.Token{ID: 2, Value: "é", Index: 0},
+				lexer.Token{ID: 2, Value: "€", Index: 2},
+				lexer.Token{ID: 1, Value: "1", Index: 5},
+			},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		{lexer.WithFallbackID(2)},
+		{lexer.WithFallbackID(2), lexer.WithBufferSize(1)},
+		{lexer.WithFallbackID(2), lexer.WithErrorToken()},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.NewNamed([]string{"[[:alpha:]]+", "[[:digit:]]+",
+			"[+-]"}, []string{"Word", "Number", "Other"}, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		for i, tc := range testCases {
+			tokens, err := l.Lex(iotest.OneByteReader(
+				strings.NewReader(tc.input)))
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					n+1, i+1, err)
+				continue
+			}
+
+			if diff := tokens.Diff(tc.tokens); diff != "" {
+				t.Errorf("option set %d, case %d, tokens not equals, %s",
+					n+1, i+1, diff)
+			} else if tokens[1].Name != "Other" {
+				t.Errorf("option set %d, case %d, got name %q, want %q",
+					n+1, i+1, tokens[1].Name, "Other")
+			}
+		}
+	}
+
+	for _, id := range []int{-1, 3} {
+		_, err := lexer.New([]string{"a", "b", "c"}, lexer.WithFallbackID(id))
+		if ferr, ok := err.(lexer.FallbackIDError); !ok {
+			t.Errorf("ID %d, got error %v, want FallbackIDError", id, err)
+		} else if ferr.ID != id || ferr.Patterns != 3 {
+			t.Errorf("got ID %d of %d patterns, want %d of 3",
+				ferr.ID, ferr.Patterns, id)
+		}
+	}
+}
+
+func TestLexerEmptyMatchPattern(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		options []lexer.Option
+		index   int
+	}{
+		// Without the error, lexing input starting with "b" would
+		// never get past the zero-width match of "a*" before it.
+
+		{[]string{"a*", "b"}, nil, 0},
+		{[]string{"b", "a*"}, nil, 1},
+		{[]string{"(x)?", "b"}, nil, 0},
+		{[]string{"a", "b"}, []lexer.Option{lexer.WithSkipPatterns("#?")}, 2},
+		{[]string{""}, nil, 0},
+	}
+
+	for n, tc := range testCases {
+		_, err := lexer.New(tc.lexemes, tc.options...)
+		if eerr, ok := err.(lexer.EmptyMatchPatternError); !ok {
+			t.Errorf("case %d, got error %v, want EmptyMatchPatternError",
+				n+1, err)
+		} else if eerr.Index != tc.index {
+			t.Errorf("case %d, got index %d, want %d",
+				n+1, eerr.Index, tc.index)
+		}
+	}
+
+	_, err := lexer.New([]string{"a", ""})
+	if want := "lexeme pattern 1 is empty"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
+
+func TestLexerNoPatterns(t *testing.T) {
+	testCases := [][]string{
+		nil,
+		{},
+	}
+
+	for n, lexemes := range testCases {
+		_, err := lexer.New(lexemes, lexer.WithSkipPatterns("#"))
+		if _, ok := err.(lexer.NoPatternsError); !ok {
+			t.Errorf("case %d, got error %v, want NoPatternsError", n+1, err)
+		}
+	}
+
+	if _, err := new(lexer.Builder).Build(); err == nil {
+		t.Errorf("got no error from empty builder, want NoPatternsError")
+	}
+}
+
+func TestLexerZeroWidthMatch(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		options []lexer.Option
+		input   string
+		pattern int
+		index   int
+	}{
+		{[]string{"[[:digit:]]+", `\b`}, nil, "1 x", 1, 2},
+		{[]string{"[[:digit:]]+", `x?\b`}, nil, "12 3 y", 1, 5},
+		{[]string{`\*`},
+			[]lexer.Option{lexer.WithSkipPatterns(`\b`)}, "*x", 1, 1},
+		{[]string{"[[:digit:]]+", `\b`},
+			[]lexer.Option{lexer.WithBufferSize(1)}, "1 x", 1, 2},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		_, err = l.LexString(tc.input)
+		if zerr, ok := err.(lexer.ZeroWidthMatchError); !ok {
+			t.Errorf("case %d, got error %v, want ZeroWidthMatchError",
+				n+1, err)
+		} else if zerr.Pattern != tc.pattern || zerr.Index != tc.index {
+			t.Errorf("case %d, got pattern %d at %d, want %d at %d",
+				n+1, zerr.Pattern, zerr.Index, tc.pattern, tc.index)
+		}
+	}
+}
+
+func TestLexerTracer(t *testing.T) {
+	var trace strings.Builder
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithSkipPatterns("#[^\n]*"), lexer.WithTracer(&trace))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if _, err := l.LexString("abc  12 #x\n"); err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := `at 0: pattern 0 matched "abc", length 3
+at 3: skipped whitespace up to 5
+at 5: pattern 1 matched "12", length 2
+at 7: skipped whitespace up to 8
+at 8: pattern 2 matched "#x", length 2, skipped
+at 10: skipped whitespace up to 11
+at 11: end of input
+`
+	if got := trace.String(); got != want {
+		t.Errorf("got trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	trace.Reset()
+	if _, err := l.LexString("abc ?"); err == nil {
+		t.Fatalf("got no error, want MatchError")
+	}
+
+	want = `at 0: pattern 0 matched "abc", length 3
+at 3: skipped whitespace up to 4
+at 4: couldn't match input at line 1, column 5: "?"
+`
+	if got := trace.String(); got != want {
+		t.Errorf("got trace:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLexerStripBOM(t *testing.T) {
+	lexemes := []string{"[[:alpha:]]+", "[[:digit:]]+"}
+	input := "how 2 fail 435 times"
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "how", Index: 3},
+		lexer.Token{ID: 1, Value: "2", Index: 7},
+		lexer.Token{ID: 0, Value: "fail", Index: 9},
+		lexer.Token{ID: 1, Value: "435", Index: 14},
+		lexer.Token{ID: 0, Value: "times", Index: 18},
+	}
+
+	optionSets := [][]lexer.Option{
+		{lexer.WithStripBOM()},
+		{lexer.WithStripBOM(), lexer.WithBufferSize(1)},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.New(lexemes, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.Lex(iotest.OneByteReader(
+			strings.NewReader("\ufeff" + input)))
+		if err != nil {
+			t.Errorf("option set %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+		if !tokens.Equals(want) {
+			t.Errorf("option set %d, got %v, want %v", n+1, tokens, want)
+		} else if tokens[0].Column != 1 {
+			t.Errorf("option set %d, got column %d, want 1",
+				n+1, tokens[0].Column)
+		}
+
+		// Input without a byte order mark is unaffected, and one
+		// anywhere other than the start is not skipped.
+
+		if tokens, err := l.LexString(input); err != nil {
+			t.Errorf("option set %d, couldn't get tokens: %v", n+1, err)
+		} else if len(tokens) != len(want) || tokens[0].Index != 0 {
+			t.Errorf("option set %d, got %v without byte order mark",
+				n+1, tokens)
+		}
+		if _, err := l.LexString("how \ufeff2"); err == nil {
+			t.Errorf("option set %d, got no error, want MatchError", n+1)
+		}
+	}
+
+	l, err := lexer.New(lexemes)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	if _, err := l.LexString("\ufeff" + input); err == nil {
+		t.Errorf("got no error without option, want MatchError")
+	}
+}
+
+func TestLexerInterning(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", ";"}, lexer.WithInterning())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("a; bc; a;")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a", Index: 0},
+		lexer.Token{ID: 1, Value: ";", Index: 1},
+		lexer.Token{ID: 0, Value: "bc", Index: 3},
+		lexer.Token{ID: 1, Value: ";", Index: 5},
+		lexer.Token{ID: 0, Value: "a", Index: 7},
+		lexer.Token{ID: 1, Value: ";", Index: 8},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+}
+
+// benchmarkInterning lexes log lines in which the same long values,
+// such as paths and user agents, are repeated many times, and keeps
+// the tokens, which is where interning saves memory. It reports the
+// memory still held once lexing is complete, as well as that allocated
+// while lexing.
+func benchmarkInterning(b *testing.B, options ...lexer.Option) {
+	l, err := lexer.New([]string{"[[:digit:]:.-]+", "[[:upper:]]+",
+		`"[^"]*"`, "/[[:alnum:]/._-]*"}, options...)
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte(strings.Repeat(`2026-10-14 12:00:01 INFO `+
+		`"GET /api/v1/customers/accounts/search HTTP/1.1" `+
+		`"Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0" `+
+		"/var/log/service/access.log\n", 2048))
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var retained uint64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		tokens, err := l.LexBytes(input)
+		if err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained += after.HeapAlloc - before.HeapAlloc
+		runtime.KeepAlive(tokens)
+	}
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+func BenchmarkWithoutInterning(b *testing.B) {
+	benchmarkInterning(b)
+}
+
+func BenchmarkWithInterning(b *testing.B) {
+	benchmarkInterning(b, lexer.WithInterning())
+}
+
+func TestLexerLexInto(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	dst := make(lexer.TokenList, 1, 8)
+	dst[0] = lexer.Token{ID: 1, Value: "9", Index: 0}
+	tokens, err := l.LexInto(strings.NewReader("ab 12"), dst)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	want := lexer.TokenList{
+		{ID: 1, Value: "9", Index: 0},
+		{ID: 0, Value: "ab", Index: 0},
+		{ID: 1, Value: "12", Index: 3},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+	if &tokens[0] != &dst[0] {
+		t.Errorf("tokens not appended to provided list")
+	}
+
+	// The tokens found before an error are still appended.
+
+	tokens, err = l.LexInto(strings.NewReader("cd ?"), tokens[:0])
+	if _, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("got error %v, want MatchError", err)
+	}
+	want = lexer.TokenList{{ID: 0, Value: "cd", Index: 0}}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+}
+
+func benchmarkLexInto(b *testing.B, pooled bool) {
+	l, err := lexer.New([]string{"if", "return", "[[:alpha:]]+",
+		"[[:digit:]]+", `\(`, `\)`, "{", "}", ";", "==", "="},
+		lexer.WithInterning())
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte(strings.Repeat("if (x == 1) { y = 2; return; }\n", 64))
+	pool := sync.Pool{New: func() interface{} {
+		return new(lexer.TokenList)
+	}}
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !pooled {
+			if _, err := l.LexBytes(input); err != nil {
+				b.Fatalf("couldn't lex input: %v", err)
+			}
+			continue
+		}
+
+		list := pool.Get().(*lexer.TokenList)
+		tokens, err := l.LexInto(bytes.NewReader(input), (*list)[:0])
+		if err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+		*list = tokens
+		pool.Put(list)
+	}
+}
+
+func BenchmarkLexFresh(b *testing.B) {
+	benchmarkLexInto(b, false)
+}
+
+func BenchmarkLexIntoPooled(b *testing.B) {
+	benchmarkLexInto(b, true)
+}
+
+func TestLexerFromRegexps(t *testing.T) {
+	res := []*regexp.Regexp{
+		regexp.MustCompile(`(?i)if`),
+		regexp.MustCompile(`(?P<first>[[:alpha:]])[[:alnum:]]*`),
+		regexp.MustCompile(`[[:digit:]]+`),
+	}
+
+	l, err := lexer.NewFromRegexps(res)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("IF x1 42")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "IF", Index: 0},
+		lexer.Token{ID: 1, Value: "x1", Index: 3},
+		lexer.Token{ID: 2, Value: "42", Index: 6},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+}
+
+func TestLexerFromRegexpsGroupNames(t *testing.T) {
+	testCases := []struct {
+		res   []*regexp.Regexp
+		index int
+		name  string
+	}{
+		{
+			[]*regexp.Regexp{
+				regexp.MustCompile(`(?P<x>a)`),
+				regexp.MustCompile(`(?P<x>b)`),
+			},
+			1, "x",
+		},
+		{
+			[]*regexp.Regexp{
+				regexp.MustCompile(`(?P<x>a)(?P<x>b)`),
+			},
+			0, "x",
+		},
+		{
+			[]*regexp.Regexp{
+				regexp.MustCompile(`a`),
+				regexp.MustCompile(`(?P<0>b)`),
+			},
+			1, "0",
+		},
+	}
+
+	for n, tc := range testCases {
+		_, err := lexer.NewFromRegexps(tc.res)
+		if gerr, ok := err.(lexer.GroupNameError); !ok {
+			t.Errorf("case %d, got error %v, want GroupNameError", n+1, err)
+		} else if gerr.Index != tc.index || gerr.Name != tc.name {
+			t.Errorf("case %d, got group %q in pattern %d, want %q in %d",
+				n+1, gerr.Name, gerr.Index, tc.name, tc.index)
+		}
+	}
+}
+
+func TestLexerN(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		tokens  int
+		n       int
+		err     bool
+	}{
+		{nil, "abc 123", 2, 7, false},
+		{nil, "abc 123  \n", 2, 10, false},
+		{nil, "", 0, 0, false},
+		{nil, "abc ? 123", 1, 4, true},
+		{[]lexer.Option{lexer.WithRuneIndex()}, "été 1 ", 2, 8, false},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{`[[:alpha:]\p{L}]+`, "[[:digit:]]+"},
+			tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, consumed, err := l.LexN([]byte(tc.input))
+		if (err != nil) != tc.err {
+			t.Errorf("case %d, got error %v, want error %t", n+1, err, tc.err)
+		}
+		if len(tokens) != tc.tokens {
+			t.Errorf("case %d, got %d tokens, want %d",
+				n+1, len(tokens), tc.tokens)
+		}
+		if consumed != tc.n {
+			t.Errorf("case %d, got %d bytes consumed, want %d",
+				n+1, consumed, tc.n)
+		}
+	}
+}
+
+func TestLexerLiterals(t *testing.T) {
+	l, err := lexer.New([]string{lexer.Literal("a+b"), "a+", "b",
+		lexer.Literal("(") + "[[:digit:]]+" + lexer.Literal(")")})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("a+b aab (12)")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a+b", Index: 0},
+		lexer.Token{ID: 1, Value: "aa", Index: 4},
+		lexer.Token{ID: 2, Value: "b", Index: 6},
+		lexer.Token{ID: 3, Value: "(12)", Index: 8},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("tokens not equals, %s", diff)
+	}
+
+	l, err = lexer.NewLiterals([]string{"(", ")", "+", "++", "[]"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err = l.LexString("(++ + [])")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want = lexer.TokenList{
+		lexer.Token{ID: 0, Value: "(", Index: 0},
+		lexer.Token{ID: 3, Value: "++", Index: 1},
+		lexer.Token{ID: 2, Value: "+", Index: 4},
+		lexer.Token{ID: 4, Value: "[]", Index: 6},
+		lexer.Token{ID: 1, Value: ")", Index: 8},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("tokens not equals, %s", diff)
+	}
+}
+
+func TestLexerSubmatches(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		input   string
+		subs    [][]string
+	}{
+		{
+			[]string{"(fr(og|ag)|toad)+", "(?P<bits>bit)+", "x"},
+			"frog toad frag bitbit x",
+			[][]string{
+				{"frog", "og"},
+				{"toad", ""},
+				{"frag", "ag"},
+				{"bit"},
+				nil,
+			},
+		},
+		{
+			[]string{"(a)(b)", "(c)"},
+			"ab c AB",
+			[][]string{{"a", "b"}, {"c"}, {"A", "B"}},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		{lexer.WithCaseInsensitive()},
+		{lexer.WithCaseInsensitive(), lexer.WithBufferSize(1)},
+		{lexer.WithCaseInsensitive(), lexer.WithFirstMatch()},
+	}
+
+	for m, options := range optionSets {
+		for n, tc := range testCases {
+			l, err := lexer.New(tc.lexemes, options...)
+			if err != nil {
+				t.Fatalf("option set %d, case %d, couldn't create lexer: %v",
+					m+1, n+1, err)
+			}
+
+			tokens, err := l.LexString(tc.input)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			if len(tokens) != len(tc.subs) {
+				t.Errorf("option set %d, case %d, got %d tokens, want %d",
+					m+1, n+1, len(tokens), len(tc.subs))
+				continue
+			}
+			for i, token := range tokens {
+				subs := l.Submatches(token)
+				if !reflect.DeepEqual(subs, tc.subs[i]) {
+					t.Errorf("option set %d, case %d, token %d, "+
+						"got submatches %q, want %q",
+						m+1, n+1, i+1, subs, tc.subs[i])
+				}
+			}
+		}
+	}
+
+	l, err := lexer.New([]string{"(a)"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	for _, token := range []lexer.Token{
+		{ID: 1, Value: "a"},
+		{ID: lexer.EOF},
+		{ID: 0, Value: "b"},
+	} {
+		if subs := l.Submatches(token); subs != nil {
+			t.Errorf("got submatches %q for %v, want none", subs, token)
+		}
+	}
+}
+
+func TestLexerGroups(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		input   string
+		subs    [][]string
+	}{
+		{
+			[]string{`(ab)\b|(a)(b)`, "[0-9]"},
+			"ab9 ab",
+			[][]string{{"", "a", "b"}, nil, {"ab", "", ""}},
+		},
+		{
+			[]string{`(?m)(a)(b)?$|(a)`, "b"},
+			"ab\nabb",
+			[][]string{{"a", "b", ""}, {"", "", "a"}, nil, nil},
+		},
+		{
+			[]string{"(if)", "x"},
+			"if x",
+			[][]string{{"if"}, nil},
+		},
+	}
+
+	optionSets := [][]lexer.Option{
+		{lexer.WithGroups()},
+		{lexer.WithGroups(), lexer.WithBufferSize(1)},
+		{lexer.WithGroups(), lexer.WithFirstMatch()},
+		{lexer.WithGroups(), lexer.WithOptimizedPattern()},
+		{lexer.WithGroups(), lexer.WithFirstByteDispatch()},
+		{lexer.WithGroups(), lexer.WithStepTimeout(time.Minute)},
+	}
+
+	for m, options := range optionSets {
+		for n, tc := range testCases {
+			l, err := lexer.New(tc.lexemes, options...)
+			if err != nil {
+				t.Fatalf("option set %d, case %d, couldn't create lexer: %v",
+					m+1, n+1, err)
+			}
+
+			tokens, err := l.LexString(tc.input)
+			if err != nil {
+				t.Errorf("option set %d, case %d, couldn't get tokens: %v",
+					m+1, n+1, err)
+				continue
+			}
+
+			if len(tokens) != len(tc.subs) {
+				t.Errorf("option set %d, case %d, got %d tokens, want %d",
+					m+1, n+1, len(tokens), len(tc.subs))
+				continue
+			}
+			for i, token := range tokens {
+				subs := l.Submatches(token)
+				if !reflect.DeepEqual(subs, tc.subs[i]) {
+					t.Errorf("option set %d, case %d, token %d, "+
+						"got submatches %q, want %q",
+						m+1, n+1, i+1, subs, tc.subs[i])
+				}
+			}
+		}
+	}
+
+	// Without the option, the groups are found from the text of the
+	// token alone, which is followed by nothing.
+
+	l, err := lexer.New(testCases[0].lexemes)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	tokens, err := l.LexString(testCases[0].input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	want := []string{"ab", "", ""}
+	if subs := l.Submatches(tokens[0]); !reflect.DeepEqual(subs, want) {
+		t.Errorf("got submatches %q, want %q", subs, want)
+	}
+}
+
+func TestLexerNewlineID(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		id      int
+		ok      bool
+	}{
+		{[]string{"[[:alpha:]]+", "\n"}, 1, true},
+		{[]string{"\n", "[[:alpha:]]+", "\n"}, 0, true},
+		{[]string{"[[:alpha:]]+", "\n+"}, 0, false},
+		{[]string{"[[:alpha:]]+"}, 0, false},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, lexer.WithNormalizeNewlines())
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		id, ok := l.NewlineID()
+		if id != tc.id || ok != tc.ok {
+			t.Errorf("case %d, got %d, %t, want %d, %t",
+				n+1, id, ok, tc.id, tc.ok)
+		}
+		if !ok {
+			continue
+		}
+
+		tokens, err := l.LexString("a\nb\r\nc\rd")
+		if err != nil {
+			t.Fatalf("case %d, couldn't lex input: %v", n+1, err)
+		}
+		if lines := tokens.Lines(); lines != 3 {
+			t.Errorf("case %d, got %d lines, want 3", n+1, lines)
+		}
+		for _, token := range tokens {
+			if token.IsNewline() != (token.ID == id) {
+				t.Errorf("case %d, got IsNewline %t for %v",
+					n+1, token.IsNewline(), token)
+			}
+		}
+	}
+
+	l, err := lexer.NewModal(map[string][]string{
+		lexer.DefaultMode: {"\n"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	if _, ok := l.NewlineID(); ok {
+		t.Errorf("got newline ID for modal lexer, want none")
+	}
+}
+
+func TestTokenListLinesTrivia(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		input   string
+		lines   int
+	}{
+		{[]string{"[[:alpha:]]+"}, "a\nb", 0},
+		{[]string{"[[:alpha:]]+"}, "a\n\nb\n", 0},
+		{[]string{"[[:alpha:]]+", "\n"}, "a\nb", 1},
+		{[]string{"[[:alpha:]]+", "\n"}, "a\n\n b\n", 3},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, lexer.WithTrivia())
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Fatalf("case %d, couldn't lex input: %v", n+1, err)
+		}
+		if lines := tokens.Lines(); lines != tc.lines {
+			t.Errorf("case %d, got %d lines, want %d", n+1, lines, tc.lines)
+		}
+		for _, token := range tokens {
+			if token.ID == lexer.Whitespace && token.IsNewline() {
+				t.Errorf("case %d, got IsNewline for %v", n+1, token)
+			}
+		}
+	}
+}
+
+func TestLexerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.txt")
+	if err := ioutil.WriteFile(path, []byte("abc 12\nd ?"), 0644); err != nil {
+		t.Fatalf("couldn't write input: %v", err)
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexFile(path)
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc", Index: 0},
+		lexer.Token{ID: 1, Value: "12", Index: 4},
+		lexer.Token{ID: 0, Value: "d", Index: 7},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("tokens not equals, %s", diff)
+	}
+	if merr, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("got error %v, want MatchError", err)
+	} else if merr.Filename != path || merr.Index != 9 {
+		t.Errorf("got error at %s:%d, want %s:9",
+			merr.Filename, merr.Index, path)
+	}
+
+	_, err = l.LexFile(filepath.Join(t.TempDir(), "missing.txt"))
+	if ierr, ok := err.(lexer.InputError); !ok {
+		t.Errorf("got error %v, want InputError", err)
+	} else if !errors.Is(ierr.Unwrap(), os.ErrNotExist) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func TestLexerStepTimeout(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithStepTimeout(20*time.Millisecond), lexer.WithBufferSize(1))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("abc 123 def")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Errorf("got %d tokens, want 3", len(tokens))
+	}
+
+	// The second token can't be found until more input is provided,
+	// or the input ends, so waiting for input which never comes takes
+	// too long.
+
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte("abc 12"))
+
+	tokens, err = l.Lex(r)
+	if terr, ok := err.(lexer.TimeoutError); !ok {
+		t.Errorf("got error %v, want TimeoutError", err)
+	} else if terr.Index != 4 || terr.Timeout != 20*time.Millisecond {
+		t.Errorf("got timeout %v at %d, want %v at 4",
+			terr.Timeout, terr.Index, 20*time.Millisecond)
+	}
+	if len(tokens) != 1 {
+		t.Errorf("got %d tokens with error, want 1", len(tokens))
+	}
+
+	// Once the step has timed out, the abandoned step finishes the read
+	// it was waiting for, but reads no more of the input.
+
+	input := &slowReader{release: make(chan struct{})}
+	if _, err := l.Lex(input); err == nil {
+		t.Fatalf("got no error, want TimeoutError")
+	}
+	close(input.release)
+	time.Sleep(50 * time.Millisecond)
+	if reads := atomic.LoadInt32(&input.reads); reads != 2 {
+		t.Errorf("got %d reads after timeout, want 2", reads)
+	}
+}
+
+// slowReader returns "abc 1", then waits to be released before
+// returning a digit from every read, counting the reads.
+type slowReader struct {
+	release chan struct{}
+	reads   int32
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	switch atomic.AddInt32(&r.reads, 1) {
+	case 1:
+		return copy(p, "abc 1"), nil
+	case 2:
+		<-r.release
+	}
+	return copy(p, "2"), nil
+}
+
+func TestLexerFrom(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+", "\n"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte("abc 12\ndef 34")
+	want := lexer.TokenList{
+		{ID: 1, Value: "34", Index: 11, Line: 2, Column: 5, End: 13},
+	}
+	tokens, err := l.LexFrom(input, 10)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+	if tokens[0].Line != 2 || tokens[0].Column != 5 || tokens[0].End != 13 {
+		t.Errorf("got token %v at line %d, column %d, want line 2, column 5",
+			tokens[0], tokens[0].Line, tokens[0].Column)
+	}
+
+	// Lexing from the start is the same as lexing the whole input.
+
+	all, err := l.LexBytes(input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	tokens, err = l.LexFrom(input, 0)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	if diff := tokens.Diff(all); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+
+	tokens, err = l.LexFrom(input, len(input))
+	if err != nil || len(tokens) != 0 {
+		t.Errorf("got tokens %v and error %v at end, want none", tokens, err)
+	}
+
+	for _, start := range []int{-1, len(input) + 1} {
+		_, err := l.LexFrom(input, start)
+		if serr, ok := err.(lexer.StartError); !ok {
+			t.Errorf("got error %v for start %d, want StartError", err, start)
+		} else if serr.Start != start || serr.Length != len(input) {
+			t.Errorf("got error %v, want start %d and length %d",
+				serr, start, len(input))
+		}
+	}
+}
+
+func TestLexerUnicodeClasses(t *testing.T) {
+	input := "Καλημέρα\u00a0κόσμε Привет,мир 42"
+	testCases := []struct {
+		options []lexer.Option
+		want    lexer.TokenList
+	}{
+		{
+			nil,
+			lexer.TokenList{
+				{ID: 0, Value: "Καλημέρα", Index: 0, End: 16},
+				{ID: 0, Value: "κόσμε", Index: 18, End: 28},
+				{ID: 1, Value: "Привет", Index: 29, End: 41},
+				{ID: 3, Value: ",", Index: 41, End: 42},
+				{ID: 1, Value: "мир", Index: 42, End: 48},
+				{ID: 2, Value: "42", Index: 49, End: 51},
+			},
+		},
+		{
+			[]lexer.Option{lexer.WithRuneIndex()},
+			lexer.TokenList{
+				{ID: 0, Value: "Καλημέρα", Index: 0, End: 8},
+				{ID: 0, Value: "κόσμε", Index: 9, End: 14},
+				{ID: 1, Value: "Привет", Index: 15, End: 21},
+				{ID: 3, Value: ",", Index: 21, End: 22},
+				{ID: 1, Value: "мир", Index: 22, End: 25},
+				{ID: 2, Value: "42", Index: 26, End: 28},
+			},
+		},
+	}
+	columns := []int{1, 10, 16, 22, 23, 27}
+
+	// The words are separated by a non-breaking space, which is also
+	// whitespace. Each option set is also tried with a buffer which is
+	// smaller than most runes, so that runes are split across reads.
+
+	for n, tc := range testCases {
+		for _, size := range []int{0, 1} {
+			options := tc.options
+			if size > 0 {
+				options = append(options, lexer.WithBufferSize(size))
+			}
+			l, err := lexer.New([]string{`\p{Greek}+`, `\p{Cyrillic}+`,
+				`\p{Nd}+`, `\p{P}`}, options...)
+			if err != nil {
+				t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+			}
+
+			tokens, err := l.Lex(iotest.OneByteReader(
+				strings.NewReader(input)))
+			if err != nil {
+				t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+			if diff := tokens.Diff(tc.want); diff != "" {
+				t.Errorf("case %d, buffer size %d, got unexpected tokens:\n%s",
+					n+1, size, diff)
+				continue
+			}
+			for i, token := range tokens {
+				if token.End != tc.want[i].End || token.Column != columns[i] {
+					t.Errorf("case %d, got token %v ending at %d, column %d, "+
+						"want %d, column %d", n+1, token, token.End,
+						token.Column, tc.want[i].End, columns[i])
+				}
+			}
+		}
+	}
+}
+
+func TestLexerForcedBoundaries(t *testing.T) {
+	input := "a=1;b=2; c;;é;"
+	testCases := []struct {
+		options []lexer.Option
+		want    []string
+	}{
+		{nil, []string{"a=1;b=2;", "c;;é;"}},
+		{
+			[]lexer.Option{lexer.WithForcedBoundaries(';')},
+			[]string{"a=1", ";", "b=2", ";", "c", ";", ";", "é", ";"},
+		},
+		{
+			[]lexer.Option{lexer.WithForcedBoundaries(';', '=')},
+			[]string{"a", "=", "1", ";", "b", "=", "2", ";", "c", ";", ";",
+				"é", ";"},
+		},
+		{
+			[]lexer.Option{lexer.WithForcedBoundaries('é', ' ')},
+			[]string{"a=1;b=2;", "c;;", "é", ";"},
+		},
+	}
+
+	for n, tc := range testCases {
+		for _, size := range []int{0, 1} {
+			options := tc.options
+			if size > 0 {
+				options = append(options, lexer.WithBufferSize(size))
+			}
+			l, err := lexer.New([]string{"[^ ]+"}, options...)
+			if err != nil {
+				t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+			}
+
+			tokens, err := l.Lex(iotest.OneByteReader(
+				strings.NewReader(input)))
+			if err != nil {
+				t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+			var values []string
+			for _, token := range tokens {
+				values = append(values, token.Value)
+			}
+			if !reflect.DeepEqual(values, tc.want) {
+				t.Errorf("case %d, buffer size %d, got %q, want %q",
+					n+1, size, values, tc.want)
+			}
+		}
+	}
+
+	// Anchors match before a boundary rune, as if the input ended
+	// there, and a boundary rune which no pattern matches can't be
+	// lexed.
+
+	l, err := lexer.New([]string{"[[:alpha:]]+$", ";"},
+		lexer.WithForcedBoundaries(';'))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	tokens, err := l.LexString("ab;cd")
+	if err != nil || len(tokens) != 3 {
+		t.Errorf("got tokens %v and error %v, want 3 tokens", tokens, err)
+	}
+
+	l, err = lexer.New([]string{"[[:alpha:]]+"},
+		lexer.WithForcedBoundaries(';'))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	if _, err := l.LexString("ab;cd"); err == nil {
+		t.Errorf("got no error for unmatched boundary rune")
+	} else if merr, ok := err.(lexer.MatchError); !ok || merr.Index != 2 {
+		t.Errorf("got error %v, want MatchError at 2", err)
+	}
+}
+
+func TestLexerCollapseNewlines(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		want    lexer.TokenList
+		ends    []int
+	}{
+		{
+			nil,
+			"a\n\n \t\nb\n",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: 1, Value: "\n", Index: 2},
+				{ID: 1, Value: "\n", Index: 5},
+				{ID: 0, Value: "b", Index: 6},
+				{ID: 1, Value: "\n", Index: 7},
+			},
+			[]int{1, 2, 3, 6, 7, 8},
+		},
+		{
+			[]lexer.Option{lexer.WithCollapseNewlines()},
+			"a\n\n \t\nb\n",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: 0, Value: "b", Index: 6},
+				{ID: 1, Value: "\n", Index: 7},
+			},
+			[]int{1, 6, 7, 8},
+		},
+		{
+			[]lexer.Option{lexer.WithCollapseNewlines(),
+				lexer.WithNormalizeNewlines()},
+			"a\r\n\r\n\rb",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: 0, Value: "b", Index: 6},
+			},
+			[]int{1, 6, 7},
+		},
+		{
+			[]lexer.Option{lexer.WithCollapseNewlines(), lexer.WithTrivia()},
+			"a\n\n \nb",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: lexer.Whitespace, Value: " ", Index: 3},
+				{ID: 1, Value: "\n", Index: 4},
+				{ID: 0, Value: "b", Index: 5},
+			},
+			[]int{1, 3, 4, 5, 6},
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{"[[:alpha:]]+", "\n"}, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+		}
+		if diff := tokens.Diff(tc.want); diff != "" {
+			t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+			continue
+		}
+		for i, token := range tokens {
+			if token.End != tc.ends[i] {
+				t.Errorf("case %d, got token %v ending at %d, want %d",
+					n+1, token, token.End, tc.ends[i])
+			}
+		}
+	}
+}
+
+func TestLexerIndentation(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		want    lexer.TokenList
+	}{
+		{
+			nil,
+			"if a\n  b\n\n  # comment\n\tc\nd",
+			lexer.TokenList{
+				{ID: 0, Value: "if", Index: 0},
+				{ID: 0, Value: "a", Index: 3},
+				{ID: 1, Value: "\n", Index: 4},
+				{ID: lexer.Indent, Value: "", Index: 7},
+				{ID: 0, Value: "b", Index: 7},
+				{ID: 1, Value: "\n", Index: 8},
+				{ID: 1, Value: "\n", Index: 9},
+				{ID: 1, Value: "\n", Index: 21},
+				{ID: lexer.Indent, Value: "", Index: 23},
+				{ID: 0, Value: "c", Index: 23},
+				{ID: 1, Value: "\n", Index: 24},
+				{ID: lexer.Dedent, Value: "", Index: 25},
+				{ID: lexer.Dedent, Value: "", Index: 25},
+				{ID: 0, Value: "d", Index: 25},
+			},
+		},
+		{
+			[]lexer.Option{lexer.WithEOFToken()},
+			"a\n b\n  c",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: lexer.Indent, Value: "", Index: 3},
+				{ID: 0, Value: "b", Index: 3},
+				{ID: 1, Value: "\n", Index: 4},
+				{ID: lexer.Indent, Value: "", Index: 7},
+				{ID: 0, Value: "c", Index: 7},
+				{ID: lexer.Dedent, Value: "", Index: 8},
+				{ID: lexer.Dedent, Value: "", Index: 8},
+				{ID: lexer.EOF, Value: "", Index: 8},
+			},
+		},
+		{
+			[]lexer.Option{lexer.WithTrivia()},
+			"a\n  b\nc",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 1, Value: "\n", Index: 1},
+				{ID: lexer.Whitespace, Value: "  ", Index: 2},
+				{ID: lexer.Indent, Value: "", Index: 4},
+				{ID: 0, Value: "b", Index: 4},
+				{ID: 1, Value: "\n", Index: 5},
+				{ID: lexer.Dedent, Value: "", Index: 6},
+				{ID: 0, Value: "c", Index: 6},
+			},
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{"[[:alpha:]]+", "\n"}, append(tc.options,
+			lexer.WithIndentation(), lexer.WithSkipPatterns("#[^\n]*"))...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+		}
+		if diff := tokens.Diff(tc.want); diff != "" {
+			t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+		}
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+", "\n"}, lexer.WithIndentation())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	_, err = l.LexString("a\n    b\n  c\n")
+	if ierr, ok := err.(lexer.IndentationError); !ok ||
+		ierr != (lexer.IndentationError{Index: 10, Line: 3, Width: 2}) {
+		t.Errorf("got error %v, want IndentationError at line 3", err)
+	}
+}
+
+func TestLexerNestedComment(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		want    lexer.TokenList
+	}{
+		{
+			nil,
+			"a /* a /* b */ c */ b",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 0, Value: "b", Index: 20},
+			},
+		},
+		{
+			nil,
+			"a/**/b /*/* */*/ / c",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: 0, Value: "b", Index: 5},
+				{ID: 1, Value: "/", Index: 17},
+				{ID: 0, Value: "c", Index: 19},
+			},
+		},
+		{
+			[]lexer.Option{lexer.WithTrivia()},
+			"a /* a /* b */ c */b",
+			lexer.TokenList{
+				{ID: 0, Value: "a", Index: 0},
+				{ID: lexer.Whitespace, Value: " ", Index: 1},
+				{ID: lexer.Comment, Value: "/* a /* b */ c */", Index: 2},
+				{ID: 0, Value: "b", Index: 19},
+			},
+		},
+	}
+
+	// Comments are found in the same way when the input is read a
+	// little at a time.
+
+	for n, tc := range testCases {
+		for _, size := range []int{0, 2} {
+			options := append(tc.options, lexer.WithNestedComment("/*", "*/"))
+			if size > 0 {
+				options = append(options, lexer.WithBufferSize(size))
+			}
+			l, err := lexer.New([]string{"[[:alpha:]]+", "/"}, options...)
+			if err != nil {
+				t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+			}
+
+			tokens, err := l.Lex(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+			if diff := tokens.Diff(tc.want); diff != "" {
+				t.Errorf("case %d, buffer size %d, got unexpected tokens:\n%s",
+					n+1, size, diff)
+			}
+		}
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+"},
+		lexer.WithNestedComment("/*", "*/"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	_, err = l.LexString("a\n /* b /* c */ d")
+	want := lexer.UnterminatedCommentError{Index: 3, Line: 2, Column: 2}
+	if cerr, ok := err.(lexer.UnterminatedCommentError); !ok || cerr != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestLexerStopPattern(t *testing.T) {
+	input := "Host: a\nAccept: b\n\nbody ? text"
+	testCases := []struct {
+		options  []lexer.Option
+		want     lexer.TokenList
+		consumed int
+	}{
+		{
+			[]lexer.Option{lexer.WithStopPattern(2, true)},
+			lexer.TokenList{
+				{ID: 0, Value: "Host:", Index: 0},
+				{ID: 1, Value: "a", Index: 6},
+				{ID: 3, Value: "\n", Index: 7},
+				{ID: 0, Value: "Accept:", Index: 8},
+				{ID: 1, Value: "b", Index: 16},
+				{ID: 2, Value: "\n\n", Index: 17},
+			},
+			19,
+		},
+		{
+			[]lexer.Option{lexer.WithStopPattern(2, false),
+				lexer.WithEOFToken(), lexer.WithRequireFullMatch()},
+			lexer.TokenList{
+				{ID: 0, Value: "Host:", Index: 0},
+				{ID: 1, Value: "a", Index: 6},
+				{ID: 3, Value: "\n", Index: 7},
+				{ID: 0, Value: "Accept:", Index: 8},
+				{ID: 1, Value: "b", Index: 16},
+			},
+			19,
+		},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{"[[:alpha:]]+:", "[[:alpha:]]+",
+			"\n\n", "\n"}, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, consumed, err := l.LexN([]byte(input))
+		if err != nil {
+			t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+		}
+		if diff := tokens.Diff(tc.want); diff != "" {
+			t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+		}
+		if consumed != tc.consumed {
+			t.Errorf("case %d, got %d bytes consumed, want %d", n+1,
+				consumed, tc.consumed)
+		}
+
+		// The scanner stops in the same place.
+
+		scanner := l.Scan(strings.NewReader(input))
+		var scanned lexer.TokenList
+		for {
+			token, err := scanner.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("case %d, got scanner error %v", n+1, err)
+			}
+			scanned = append(scanned, token)
+		}
+		if diff := scanned.Diff(tc.want); diff != "" {
+			t.Errorf("case %d, got unexpected scanned tokens:\n%s", n+1,
+				diff)
+		}
+	}
+
+	// Without a newline pattern, newlines are skipped as whitespace,
+	// so a blank line is never seen.
+
+	l, err := lexer.New([]string{"[a-z]+", "\n\n"},
+		lexer.WithStopPattern(1, true))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	tokens, _, err := l.LexN([]byte("a\nb\n\nbody ?"))
+	if merr, ok := err.(lexer.MatchError); !ok || merr.Index != 10 {
+		t.Errorf("got error %v, want MatchError at 10", err)
+	}
+	if len(tokens) != 3 || tokens[2].Value != "body" {
+		t.Errorf("got %v, want tokens up to body", tokens)
+	}
+
+	_, err = lexer.New([]string{"a"}, lexer.WithStopPattern(1, true))
+	want := lexer.StopPatternError{ID: 1, Patterns: 1}
+	if serr, ok := err.(lexer.StopPatternError); !ok || serr != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
+
+func TestMatchErrorPossiblyIncomplete(t *testing.T) {
+	testCases := []struct {
+		input      string
+		index      int
+		incomplete bool
+	}{
+		{"a \"bc", 2, true},
+		{"a \"bc\nde", 2, true},
+		{"a /* b", 2, true},
+		{"a ? b", 2, false},
+		{"a \"bc ? \" ?", 10, false},
+		{"a /", 2, true},
+	}
+
+	for n, tc := range testCases {
+		for _, size := range []int{0, 2} {
+			options := []lexer.Option{lexer.WithSkipPatterns(`/\*.*?\*/`)}
+			if size > 0 {
+				options = append(options, lexer.WithBufferSize(size))
+			}
+			l, err := lexer.New([]string{"[[:alpha:]]+", `"[^"]*"`},
+				options...)
+			if err != nil {
+				t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+			}
+
+			_, err = l.Lex(strings.NewReader(tc.input))
+			merr, ok := err.(lexer.MatchError)
+			if !ok {
+				t.Errorf("case %d, got error %v, want MatchError", n+1, err)
+				continue
+			}
+			if merr.Index != tc.index ||
+				merr.PossiblyIncomplete != tc.incomplete {
+				t.Errorf("case %d, buffer size %d, got error at %d, "+
+					"possibly incomplete %t, want %d, %t", n+1, size,
+					merr.Index, merr.PossiblyIncomplete, tc.index,
+					tc.incomplete)
+			}
+		}
+	}
+}
+
+func TestLexerSignificantWhitespace(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:] ]+", "[[:digit:]]+", ",",
+		"\n"}, lexer.WithSignificantWhitespace())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("new york, los angeles,12\n san jose")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	want := lexer.TokenList{
+		{ID: 0, Value: "new york", Index: 0},
+		{ID: 2, Value: ",", Index: 8},
+		{ID: 0, Value: " los angeles", Index: 9},
+		{ID: 2, Value: ",", Index: 21},
+		{ID: 1, Value: "12", Index: 22},
+		{ID: 3, Value: "\n", Index: 24},
+		{ID: 0, Value: " san jose", Index: 25},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+
+	// Whitespace which no pattern matches is an error.
+
+	_, err = l.LexString("12 ,\t3")
+	if merr, ok := err.(lexer.MatchError); !ok || merr.Index != 4 {
+		t.Errorf("got error %v, want MatchError at 4", err)
+	}
+}
+
+func TestLexerWithoutValues(t *testing.T) {
+	input := []byte("if abc 12\n  x ?")
+	options := []lexer.Option{lexer.WithErrorToken(), lexer.WithTrivia()}
+
+	var lexers []*lexer.Lexer
+	for _, options := range [][]lexer.Option{options,
+		append(options, lexer.WithoutValues())} {
+		l, err := lexer.NewKeywords("[[:alpha:]]+", []string{"if"},
+			options...)
+		if err != nil {
+			t.Fatalf("couldn't create lexer: %v", err)
+		}
+		if l, err = l.With("[[:digit:]]+", "\n"); err != nil {
+			t.Fatalf("couldn't add patterns: %v", err)
+		}
+		lexers = append(lexers, l)
+	}
+	plain, bare := lexers[0], lexers[1]
+
+	want, err := plain.LexBytes(input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	tokens, err := bare.LexBytes(input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %v, want %v", tokens, want)
+	}
+
+	for i, token := range tokens {
+		if token.Value != "" && !token.IsNewline() {
+			t.Errorf("got value %q for token %d, want none", token.Value,
+				i+1)
+		}
+		if value := string(input[token.Start():token.End]); value !=
+			want[i].Value {
+			t.Errorf("got value %q for token %d, want %q", value, i+1,
+				want[i].Value)
+		}
+		token.Value = want[i].Value
+		if token != want[i] {
+			t.Errorf("got %+v, want %+v", token, want[i])
+		}
+	}
+}
+
+func TestLexerDecoder(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", `"([^"\\]|\\.)*"`},
+		lexer.WithDecoder(1, strconv.Unquote), lexer.WithFilename("in"))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := `ab "c\td" "\u00e9"`
+	tokens, err := l.LexString(input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	want := lexer.TokenList{
+		{ID: 0, Value: "ab", Index: 0},
+		{ID: 1, Value: "c\td", Index: 3},
+		{ID: 1, Value: "é", Index: 10},
+	}
+	if diff := tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+	for i, raw := range []string{"", `"c\td"`, `"\u00e9"`} {
+		if i < len(tokens) && tokens[i].Raw != raw {
+			t.Errorf("case %d, got raw %q, want %q", i+1, tokens[i].Raw,
+				raw)
+		}
+	}
+	if got := tokens.Reconstruct(); got != input {
+		t.Errorf("got reconstructed %q, want %q", got, input)
+	}
+
+	// The stream lexer finds where each token starts from its raw text.
+
+	stream := l.Stream()
+	stream.Feed([]byte(input[:8]))
+	drained, _ := stream.Drain()
+	stream.Feed([]byte(input[8:]))
+	more, _ := stream.Drain()
+	rest, err := stream.Close()
+	if err != nil {
+		t.Fatalf("couldn't close stream: %v", err)
+	}
+	streamed := append(append(drained, more...), rest...)
+	if diff := streamed.Diff(want); diff != "" {
+		t.Errorf("got unexpected streamed tokens:\n%s", diff)
+	}
+
+	tokens, err = l.LexString("ab\n  \"\\q\"")
+	derr, ok := err.(lexer.DecodeError)
+	if !ok || derr.ID != 1 || derr.Value != `"\q"` || derr.Index != 5 ||
+		derr.Line != 2 || derr.Column != 3 || derr.Filename != "in" {
+		t.Fatalf("got error %#v, want DecodeError at 5", err)
+	}
+	if !errors.Is(err, strconv.ErrSyntax) {
+		t.Errorf("got error %v, want it to wrap %v", err, strconv.ErrSyntax)
+	}
+	msg := `in:2:3: couldn't decode "\"\\q\"": invalid syntax`
+	if err.Error() != msg {
+		t.Errorf("got message %q, want %q", err.Error(), msg)
+	}
+	if len(tokens) != 1 || tokens[0].Value != "ab" {
+		t.Errorf("got %v before error, want ab", tokens)
+	}
+
+	// Without values, there is nothing to decode.
+
+	bare, err := lexer.New([]string{"[[:alpha:]]+", `"([^"\\]|\\.)*"`},
+		lexer.WithDecoder(1, strconv.Unquote), lexer.WithoutValues())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	if tokens, err = bare.LexString(`ab "\q"`); err != nil {
+		t.Errorf("got error %v without values, want none", err)
+	} else if len(tokens) != 2 || tokens[1].Raw != "" {
+		t.Errorf("got %+v without values, want no raw text", tokens)
+	}
+}
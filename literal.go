@@ -0,0 +1,75 @@
+package lexer
+
+// literalNode is a node in a trie of literal lexeme patterns, which
+// lets the lexer match lexemes without the regular expression engine
+// if all of its patterns are literal strings. Each node represents the
+// string of bytes on the path to it from the root, and id is the index
+// of the pattern which is that string, or -1 if none is.
+type literalNode struct {
+	next map[byte]*literalNode
+	id   int
+}
+
+// newLiteralTrie returns the root of a trie of the provided literal
+// strings, none of which may be empty.
+func newLiteralTrie(literals []string) *literalNode {
+	root := &literalNode{id: -1}
+	for id, literal := range literals {
+		node := root
+		for i := 0; i < len(literal); i++ {
+			child, ok := node.next[literal[i]]
+			if !ok {
+				if node.next == nil {
+					node.next = make(map[byte]*literalNode)
+				}
+				child = &literalNode{id: -1}
+				node.next[literal[i]] = child
+			}
+			node = child
+		}
+
+		// If the same literal appears more than once, the first
+		// pattern wins, as it would with the combined expression.
+
+		if node.id == -1 {
+			node.id = id
+		}
+	}
+
+	return root
+}
+
+// matchLiteral finds the literal pattern which matches at the current
+// index of the buffer, and returns its id and the length of the match,
+// or a length of -1 if no pattern matches. The longest literal wins,
+// unless the lexer was created with the WithFirstMatch option, in which
+// case the first matching pattern wins, just as for the combined
+// expression. More input is read as necessary.
+func (l *Lexer) matchLiteral(b *indexedBuffer) (int, int, Error) {
+	id, length := -1, -1
+	node := l.literals
+	for i := 0; ; i++ {
+		if node.id != -1 && (!l.firstMatch || id == -1 || node.id < id) {
+			id, length = node.id, i
+		}
+		if node.next == nil {
+			break
+		}
+
+		for b.index+i >= len(b.buffer) && !b.complete() {
+			if err := b.fill(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if b.index+i >= len(b.buffer) {
+			break
+		}
+
+		next, ok := node.next[b.buffer[b.index+i]]
+		if !ok {
+			break
+		}
+		node = next
+	}
+	return id, length, nil
+}
@@ -0,0 +1,101 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"strings"
+	"testing"
+)
+
+// operators are literal lexeme patterns, some of which are prefixes
+// of others, and one of which appears twice.
+var operators = []string{"=", "==", "!=", "<", "<=", "<<", "<<=", `\+`,
+	`\+\+`, `\+=`, "-", "->", "=", `\(`, `\)`, "\n"}
+
+func TestLiteralMatchesRegexp(t *testing.T) {
+	inputs := []string{
+		"== = != <<= << <= < ++ += + -> - ( )",
+		"<<==+++=->\n(==)\n\n",
+		"   ",
+		"<<=!",
+		"!",
+	}
+
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithBufferSize(1)},
+		{lexer.WithFirstMatch()},
+		{lexer.WithSkipPatterns("!!")},
+		{lexer.WithTrivia(), lexer.WithEOFToken()},
+	}
+
+	for n, options := range optionSets {
+		literal, err := lexer.New(operators, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		// A pattern which isn't literal, and which matches none of
+		// the inputs, forces the lexer to use the regular expression
+		// engine without changing the tokens it finds.
+
+		lexemes := append(operators[:len(operators):len(operators)],
+			"[[:alpha:]]+")
+		regex, err := lexer.New(lexemes, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		for i, input := range inputs {
+			got, gotErr := literal.LexString(input)
+			want, wantErr := regex.LexString(input)
+
+			if (gotErr == nil) != (wantErr == nil) {
+				t.Errorf("option set %d, case %d, got error %v, want %v",
+					n+1, i+1, gotErr, wantErr)
+			} else if gotErr != nil && gotErr.Error() != wantErr.Error() {
+				t.Errorf("option set %d, case %d, got error %v, want %v",
+					n+1, i+1, gotErr, wantErr)
+			}
+
+			if !got.Equals(want) {
+				t.Errorf("option set %d, case %d, got %v, want %v",
+					n+1, i+1, got, want)
+				continue
+			}
+			for j := range got {
+				if got[j].Line != want[j].Line || got[j].End != want[j].End {
+					t.Errorf("option set %d, case %d, token %d, got "+
+						"line %d, end %d, want line %d, end %d", n+1, i+1,
+						j+1, got[j].Line, got[j].End, want[j].Line,
+						want[j].End)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkOperators(b *testing.B, lexemes []string) {
+	l, err := lexer.New(lexemes)
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := []byte(strings.Repeat("<<= ++ -> == != ( <= ) += <<\n", 4096))
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := l.LexBytes(input); err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+	}
+}
+
+func BenchmarkOperatorsLiteral(b *testing.B) {
+	benchmarkOperators(b, operators)
+}
+
+func BenchmarkOperatorsRegexp(b *testing.B) {
+	benchmarkOperators(b, append(operators[:len(operators):len(operators)],
+		"[[:alpha:]]+"))
+}
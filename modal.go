@@ -0,0 +1,128 @@
+package lexer
+
+// DefaultMode is the name of the mode in which a modal lexer starts
+// lexing each input.
+const DefaultMode = "default"
+
+// modeOp identifies the kind of change an Action makes to the stack
+// of modes of a modal lexer.
+type modeOp int
+
+const (
+	opNone modeOp = iota
+	opPush
+	opPop
+	opBegin
+)
+
+// Action is a change to the mode of a modal lexer, made when a token
+// matching the lexeme pattern with which it is associated is found.
+// The zero value of Action leaves the mode unchanged.
+type Action struct {
+	op   modeOp
+	mode string
+}
+
+// Push returns an action which enters the named mode, remembering the
+// current mode so that it may later be returned to with Pop.
+func Push(mode string) Action {
+	return Action{opPush, mode}
+}
+
+// Pop returns an action which returns to the mode which was current
+// when the current mode was entered with Push. Popping the mode in
+// which lexing started leaves the mode unchanged.
+func Pop() Action {
+	return Action{op: opPop}
+}
+
+// Begin returns an action which replaces the current mode with the
+// named mode, without remembering the current mode.
+func Begin(mode string) Action {
+	return Action{opBegin, mode}
+}
+
+// NewModal creates a new modal lexer, which has a separate slice of
+// lexeme patterns for each of a number of named modes. Only the
+// patterns of the current mode are used to identify the next token,
+// and lexing starts in DefaultMode, which must be one of the modes.
+// The ID of each token is the index of the pattern matched in the
+// slice for its mode, and its Mode is the name of that mode. The
+// actions for each mode, if any are provided, must be in the same
+// order as its patterns, and the action associated with the pattern
+// used to identify a token is applied after the token is found. The
+// options apply to every mode.
+func NewModal(modes map[string][]string, actions map[string][]Action,
+	options ...Option) (*Lexer, Error) {
+	if _, ok := modes[DefaultMode]; !ok {
+		return nil, newModeError(DefaultMode)
+	}
+
+	lexers := make(map[string]*Lexer, len(modes))
+	for mode, lexemes := range modes {
+		lexer, err := New(lexemes, options...)
+		if err != nil {
+			return nil, err
+		}
+		lexers[mode] = lexer
+	}
+
+	for mode, modeActions := range actions {
+		lexemes, ok := modes[mode]
+		if !ok {
+			return nil, newModeError(mode)
+		}
+		if len(modeActions) != len(lexemes) {
+			return nil, newActionsError(mode, len(lexemes),
+				len(modeActions))
+		}
+		for _, action := range modeActions {
+			if action.op != opPush && action.op != opBegin {
+				continue
+			}
+			if _, ok := modes[action.mode]; !ok {
+				return nil, newModeError(action.mode)
+			}
+		}
+	}
+
+	lexer := Lexer{
+		modes:   lexers,
+		actions: actions,
+		config:  newConfig(options),
+	}
+	return &lexer, nil
+}
+
+// scanModal gets the next token from a buffer in the same way as
+// scan, using the lexer for the current mode of the buffer, and then
+// applies any action associated with the pattern which was matched.
+func (l *Lexer) scanModal(b *indexedBuffer) (Token, bool, Error) {
+	if b.modes == nil {
+		b.modes = []string{DefaultMode}
+	}
+
+	mode := b.modes[len(b.modes)-1]
+	token, ok, err := l.modes[mode].scan(b)
+	if err != nil || !ok {
+		return token, ok, err
+	}
+	token.Mode = mode
+
+	if token.ID < 0 || token.ID >= len(l.actions[mode]) {
+		return token, true, nil
+	}
+
+	switch action := l.actions[mode][token.ID]; action.op {
+	case opPush:
+		b.modes = append(b.modes, action.mode)
+	case opPop:
+		if len(b.modes) > 1 {
+			b.modes = b.modes[:len(b.modes)-1]
+		}
+	case opBegin:
+		b.modes[len(b.modes)-1] = action.mode
+	}
+
+	return token, true, nil
+}
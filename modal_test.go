@@ -0,0 +1,147 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+func TestModalPushPop(t *testing.T) {
+	modes := map[string][]string{
+		lexer.DefaultMode: {"[^{]+", `\{\{`},
+		"expr":            {"[a-z]+", `\|`, `\}\}`},
+	}
+	actions := map[string][]lexer.Action{
+		lexer.DefaultMode: {{}, lexer.Push("expr")},
+		"expr":            {{}, {}, lexer.Pop()},
+	}
+
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithBufferSize(1)},
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "Hello ", Index: 0, Mode: "default"},
+		lexer.Token{ID: 1, Value: "{{", Index: 6, Mode: "default"},
+		lexer.Token{ID: 0, Value: "name", Index: 9, Mode: "expr"},
+		lexer.Token{ID: 1, Value: "|", Index: 14, Mode: "expr"},
+		lexer.Token{ID: 0, Value: "upper", Index: 16, Mode: "expr"},
+		lexer.Token{ID: 2, Value: "}}", Index: 22, Mode: "expr"},
+		lexer.Token{ID: 0, Value: "! ", Index: 24, Mode: "default"},
+	}
+
+	for n, options := range optionSets {
+		l, err := lexer.NewModal(modes, actions, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString("Hello {{ name | upper }}! ")
+		if err != nil {
+			t.Errorf("option set %d, couldn't lex input: %v", n+1, err)
+			continue
+		}
+
+		if !tokens.Equals(want) {
+			t.Errorf("option set %d, got %v, want %v", n+1, tokens, want)
+			continue
+		}
+		for i, token := range tokens {
+			if token.Mode != want[i].Mode {
+				t.Errorf("option set %d, token %d, got mode %q, want %q",
+					n+1, i+1, token.Mode, want[i].Mode)
+			}
+		}
+	}
+}
+
+func TestModalBegin(t *testing.T) {
+	modes := map[string][]string{
+		lexer.DefaultMode: {"a", "x"},
+		"b":               {"b", "x"},
+	}
+	actions := map[string][]lexer.Action{
+		lexer.DefaultMode: {lexer.Begin("b"), lexer.Pop()},
+		"b":               {lexer.Begin(lexer.DefaultMode), lexer.Pop()},
+	}
+
+	l, err := lexer.NewModal(modes, actions)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	// Popping the only mode on the stack leaves the mode unchanged,
+	// whether or not it has been replaced with Begin.
+
+	tokens, err := l.LexString("x a x b x a")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "x", Index: 0},
+		lexer.Token{ID: 0, Value: "a", Index: 2},
+		lexer.Token{ID: 1, Value: "x", Index: 4},
+		lexer.Token{ID: 0, Value: "b", Index: 6},
+		lexer.Token{ID: 1, Value: "x", Index: 8},
+		lexer.Token{ID: 0, Value: "a", Index: 10},
+	}
+	if !tokens.Equals(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+
+	// The "b" pattern doesn't exist in the default mode.
+
+	if _, err := l.LexString("a b b"); err == nil {
+		t.Errorf("got no error, want MatchError")
+	} else if merr, ok := err.(lexer.MatchError); !ok {
+		t.Errorf("got error %v, want MatchError", err)
+	} else if merr.Index != 4 {
+		t.Errorf("got error at index %d, want 4", merr.Index)
+	}
+}
+
+func TestModalBadModes(t *testing.T) {
+	testCases := []struct {
+		modes   map[string][]string
+		actions map[string][]lexer.Action
+		mode    string
+	}{
+		{
+			map[string][]string{"other": {"a"}},
+			nil,
+			lexer.DefaultMode,
+		},
+		{
+			map[string][]string{lexer.DefaultMode: {"a"}},
+			map[string][]lexer.Action{lexer.DefaultMode: {lexer.Push("b")}},
+			"b",
+		},
+		{
+			map[string][]string{lexer.DefaultMode: {"a"}},
+			map[string][]lexer.Action{"c": {lexer.Pop()}},
+			"c",
+		},
+	}
+
+	for n, tc := range testCases {
+		_, err := lexer.NewModal(tc.modes, tc.actions)
+		if merr, ok := err.(lexer.ModeError); !ok {
+			t.Errorf("case %d, got error %v, want ModeError", n+1, err)
+		} else if merr.Mode != tc.mode {
+			t.Errorf("case %d, got mode %q, want %q", n+1, merr.Mode, tc.mode)
+		}
+	}
+
+	_, err := lexer.NewModal(map[string][]string{
+		lexer.DefaultMode: {"a", "b"},
+	}, map[string][]lexer.Action{
+		lexer.DefaultMode: {lexer.Pop()},
+	})
+	if aerr, ok := err.(lexer.ActionsError); !ok {
+		t.Errorf("got error %v, want ActionsError", err)
+	} else if aerr.Patterns != 2 || aerr.Actions != 1 {
+		t.Errorf("got %d actions for %d patterns, want 1 for 2",
+			aerr.Actions, aerr.Patterns)
+	}
+}
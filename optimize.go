@@ -0,0 +1,147 @@
+package lexer
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// optimizedMatcher finds lexemes with a combined expression which has
+// no capturing groups, for a lexer created with the WithOptimizedPattern
+// option. Without groups around each pattern, the regular expression
+// parser is free to factor out prefixes which alternatives have in
+// common, so that a large set of keywords becomes something closer to
+// a trie, and the engine has no submatches to track. The combined
+// expression only tells us the length of the match, so the pattern
+// which matched is then identified separately. Literal patterns are
+// identified by their value, and any other pattern by matching it on
+// its own, so the ID is the same as it would be with the groups.
+type optimizedMatcher struct {
+	combined   *regexp.Regexp
+	anchored   []*regexp.Regexp
+	literals   map[string]int
+	firstMatch bool
+}
+
+// newOptimizedMatcher creates a matcher for the provided patterns,
+// including any skip patterns, which must already have been checked
+// to be valid. The flags are those for the combined expression of the
+// lexer, which also apply to each pattern. If the flags are non-empty,
+// which means that case is being ignored, literal patterns can't be
+// identified by their value, so every pattern is matched on its own.
+func newOptimizedMatcher(patterns []string, flags string,
+	firstMatch bool) (*optimizedMatcher, Error) {
+	m := &optimizedMatcher{
+		anchored:   make([]*regexp.Regexp, len(patterns)),
+		literals:   make(map[string]int),
+		firstMatch: firstMatch,
+	}
+
+	alternatives := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		parsed, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return nil, newRegexError(err)
+		}
+		alternatives[i] = withoutCaptures(parsed).String()
+
+		// If the same literal appears more than once, the first
+		// pattern wins, as it would with the combined expression.
+
+		compiled := regexp.MustCompile(pattern)
+		literal, complete := compiled.LiteralPrefix()
+		if complete && flags == "" {
+			if _, ok := m.literals[literal]; !ok {
+				m.literals[literal] = i
+			}
+			continue
+		}
+
+		m.anchored[i] = regexp.MustCompile(flags + "^(?:" + pattern + ")")
+		if !firstMatch {
+			m.anchored[i].Longest()
+		}
+	}
+
+	combined, err := regexp.Compile(flags + "^(?:" +
+		strings.Join(alternatives, "|") + ")")
+	if err != nil {
+		return nil, newRegexError(err)
+	}
+	if !firstMatch {
+		combined.Longest()
+	}
+	m.combined = combined
+
+	return m, nil
+}
+
+// withoutCaptures replaces every capturing group in the provided
+// expression with its contents, modifying it in place, and returns it.
+func withoutCaptures(re *syntax.Regexp) *syntax.Regexp {
+	for re.Op == syntax.OpCapture {
+		re = re.Sub[0]
+	}
+	for i, sub := range re.Sub {
+		re.Sub[i] = withoutCaptures(sub)
+	}
+	return re
+}
+
+// match finds the pattern which matches at the current index of the
+// buffer in the same way as matchRegexp, reading more input as
+// necessary.
+func (m *optimizedMatcher) match(b *indexedBuffer) (int, int, Error) {
+	loc, err := findIndex(b, m.combined)
+	if err != nil {
+		return 0, 0, err
+	}
+	if loc == nil {
+		return 0, -1, nil
+	}
+	length := loc[1]
+
+	// The pattern which matched is the first which would have matched
+	// on its own, with the same length unless the first match wins,
+	// so no pattern after a literal with the same value can be it.
+
+	last, isLiteral := m.literals[string(b.buffer[b.index:b.index+length])]
+	if !isLiteral {
+		last = len(m.anchored)
+	}
+	for id, pattern := range m.anchored[:last] {
+		if pattern == nil {
+			continue
+		}
+		loc, err := findIndex(b, pattern)
+		if err != nil {
+			return 0, 0, err
+		}
+		if loc != nil && (m.firstMatch || loc[1] == length) {
+			return id, length, nil
+		}
+	}
+	if isLiteral {
+		return last, length, nil
+	}
+
+	return 0, 0, newInternalMatchError(b.offset(),
+		string(b.buffer[b.index:b.index+length]))
+}
+
+// findIndex returns the location of the match of the provided
+// expression at the current index of the buffer, or nil if there is
+// none, reading more input into the buffer on demand if it isn't all
+// there, so that the engine can look as far ahead as it needs to.
+func findIndex(b *indexedBuffer, re *regexp.Regexp) ([]int, Error) {
+	if b.complete() {
+		return re.FindIndex(b.next()), nil
+	}
+
+	reader := b.runeReader()
+	loc := re.FindReaderIndex(reader)
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return loc, nil
+}
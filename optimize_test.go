@@ -0,0 +1,108 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"strings"
+	"testing"
+)
+
+func TestOptimizedPattern(t *testing.T) {
+	patterns := []string{
+		"if", "int", "in", "interface", `(i)(n)+t?`, "[[:alpha:]]+",
+		`[[:digit:]]+(\.[[:digit:]]+)?`, "=", "==", `\+`,
+	}
+	inputs := []string{
+		"if int in interface innt inn x",
+		"x == 1.5 + 22 = iff",
+		"IF Int innnt INTERFACE",
+		"interfaces # comment\nin",
+		"a ? b",
+	}
+	optionSets := [][]lexer.Option{
+		nil,
+		{lexer.WithFirstMatch()},
+		{lexer.WithCaseInsensitive()},
+		{lexer.WithSkipPatterns("#[^\n]*")},
+		{lexer.WithBufferSize(3)},
+		{lexer.WithFirstMatch(), lexer.WithBufferSize(2)},
+	}
+
+	for n, options := range optionSets {
+		plain, err := lexer.New(patterns, options...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create lexer: %v", n+1, err)
+		}
+		optimized, err := lexer.New(patterns, append(options,
+			lexer.WithOptimizedPattern())...)
+		if err != nil {
+			t.Fatalf("option set %d, couldn't create optimized lexer: %v",
+				n+1, err)
+		}
+
+		for i, input := range inputs {
+			want, werr := plain.LexString(input)
+			tokens, err := optimized.LexString(input)
+			if (err == nil) != (werr == nil) ||
+				(err != nil && err.Error() != werr.Error()) {
+				t.Errorf("option set %d, case %d, got error %v, want %v",
+					n+1, i+1, err, werr)
+			}
+			if diff := tokens.Diff(want); diff != "" {
+				t.Errorf("option set %d, case %d, got unexpected tokens:\n%s",
+					n+1, i+1, diff)
+			}
+		}
+	}
+}
+
+// benchmarkKeywords returns several hundred distinct keywords, many of
+// which share prefixes, as in a large language or query dialect.
+func benchmarkKeywords() []string {
+	var keywords []string
+	for _, first := range []string{"a", "be", "con", "de", "ex", "for",
+		"in", "pro", "re", "sub", "trans", "un"} {
+		for _, second := range []string{"bit", "cast", "duct", "fer",
+			"gate", "ject", "lock", "mit", "port", "sert", "tain",
+			"vert", "wind", "zone", "ple", "quire", "scope", "tract",
+			"form", "pend", "roll", "sign", "stall", "tend", "value"} {
+			keywords = append(keywords, first+second)
+		}
+	}
+	return keywords
+}
+
+func benchmarkOptimizedPattern(b *testing.B, options ...lexer.Option) {
+	keywords := benchmarkKeywords()
+	l, err := lexer.New(append(keywords, "[[:alpha:]]+", "[[:digit:]]+"),
+		options...)
+	if err != nil {
+		b.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	var input strings.Builder
+	for i := 0; i < 8; i++ {
+		for j, keyword := range keywords {
+			if j%5 == 0 {
+				input.WriteString("name 42 ")
+			}
+			input.WriteString(keyword + " ")
+		}
+	}
+	data := []byte(input.String())
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := l.LexBytes(data); err != nil {
+			b.Fatalf("couldn't lex input: %v", err)
+		}
+	}
+}
+
+func BenchmarkKeywordsPlain(b *testing.B) {
+	benchmarkOptimizedPattern(b)
+}
+
+func BenchmarkKeywordsOptimized(b *testing.B) {
+	benchmarkOptimizedPattern(b, lexer.WithOptimizedPattern())
+}
@@ -0,0 +1,538 @@
+package lexer
+
+import (
+	"io"
+	"time"
+	"unicode"
+)
+
+// Option configures a lexer at creation time. Options are passed to
+// New, or any of the other functions which create a lexer, and are
+// applied in the order in which they are provided, so that where two
+// options conflict the later one prevails.
+type Option func(*config)
+
+// config holds the settings which may be changed by options
+// passed to New.
+type config struct {
+	runeIndex         bool
+	bufferSize        int
+	skipPatterns      []string
+	isSpace           func(rune) bool
+	caseInsensitive   bool
+	eofToken          bool
+	trivia            bool
+	normalizeNewlines bool
+	firstMatch        bool
+	maxInputBytes     int
+	maxTokenBytes     int
+	maxTokens         int
+	filename          string
+	requireFullMatch  bool
+	errorToken        bool
+	tracer            io.Writer
+	stripBOM          bool
+	interning         bool
+	stats             bool
+	fallback          bool
+	fallbackID        int
+	stepTimeout       time.Duration
+	optimizedPattern  bool
+	boundaries        []rune
+	collapseNewlines  bool
+	indentation       bool
+	commentOpen       string
+	commentClose      string
+	stop              bool
+	stopID            int
+	stopInclude       bool
+	significantSpace  bool
+	withoutValues     bool
+	firstByteDispatch bool
+	decoders          map[int]func(raw string) (string, error)
+	recordGroups      bool
+}
+
+// newConfig returns the configuration resulting from applying the
+// provided options to the default configuration.
+func newConfig(options []Option) config {
+	c := config{
+		isSpace: unicode.IsSpace,
+	}
+	for _, option := range options {
+		option(&c)
+	}
+	if c.significantSpace {
+		c.isSpace = noSpace
+	}
+	return c
+}
+
+// noSpace treats no rune as whitespace, for the
+// WithSignificantWhitespace option.
+func noSpace(rune) bool {
+	return false
+}
+
+// WithRuneIndex causes the lexer to report positions, including
+// Token.Index, Token.End and MatchError.Index, as offsets in runes
+// rather than in bytes. This is useful when the input may contain
+// multi-byte UTF-8 characters and positions are to be compared with
+// those from a rune-based source. By default, positions are offsets
+// in bytes.
+func WithRuneIndex() Option {
+	return func(c *config) {
+		c.runeIndex = true
+	}
+}
+
+// WithBufferSize causes the lexer to read its input incrementally,
+// n bytes at a time, rather than reading the entire input into
+// memory before lexing it. Input which has already been translated
+// into tokens is discarded as more is read, so very large inputs
+// may be lexed using only a modest amount of memory. Lexemes longer
+// than n bytes are still recognized, since more input is read
+// whenever the regular expression engine needs to look further
+// ahead, and the resulting tokens are identical to those found
+// when the entire input is read at once, which is the default.
+func WithBufferSize(n int) Option {
+	return func(c *config) {
+		c.bufferSize = n
+	}
+}
+
+// WithSkipPatterns provides regular expressions to match input which
+// should be consumed but otherwise ignored, such as comments. Skip
+// patterns take part in finding the longest match at each position
+// in the same way as lexeme patterns, but when one of them is
+// matched, no token is produced. When a skip pattern and a lexeme
+// pattern match equally long runs of input, the lexeme pattern is
+// preferred.
+func WithSkipPatterns(patterns ...string) Option {
+	return func(c *config) {
+		c.skipPatterns = append(c.skipPatterns, patterns...)
+	}
+}
+
+// WithWhitespace provides a function which the lexer will use to
+// decide which characters are whitespace to be skipped between
+// tokens, in place of unicode.IsSpace. This allows, for instance,
+// commas to be treated as separators, or some spaces to be treated
+// as significant. Regardless of this function, the newline
+// character is not skipped if it is one of the lexeme patterns.
+func WithWhitespace(isSpace func(rune) bool) Option {
+	return func(c *config) {
+		c.isSpace = isSpace
+	}
+}
+
+// WithCaseInsensitive causes the lexeme patterns, and any skip
+// patterns, to match without regard to case, so that, for example,
+// the pattern "select" will match "SELECT" and "Select". The values
+// of the tokens found retain the case of the input.
+func WithCaseInsensitive() Option {
+	return func(c *config) {
+		c.caseInsensitive = true
+	}
+}
+
+// WithEOFToken causes the lexer to produce a final token with the ID
+// EOF after all the other tokens, with an empty value and with the
+// length of the input as its index. By default, no such token is
+// produced.
+func WithEOFToken() Option {
+	return func(c *config) {
+		c.eofToken = true
+	}
+}
+
+// WithTrivia causes the lexer to produce tokens with the ID Whitespace
+// containing the whitespace it skips between other tokens, so that
+// the original input may be reproduced exactly. Each maximal run of
+// whitespace produces a single token, which includes any newline
+// characters unless the newline character is one of the lexeme
+// patterns, in which case each newline character is returned as a
+// token of its own as usual. By default, whitespace is discarded.
+func WithTrivia() Option {
+	return func(c *config) {
+		c.trivia = true
+	}
+}
+
+// WithNormalizeNewlines causes the lexer to treat a carriage return,
+// either alone or followed by a newline character, as a single newline
+// character, both when skipping whitespace and when the newline
+// character is one of the lexeme patterns, in which case the value of
+// the newline token is always "\n". This takes precedence over any
+// other lexeme pattern which would match a carriage return. Positions
+// continue to refer to the original input, so the index of the token
+// following a carriage return and newline pair is two bytes after that
+// of the newline token. By default, a carriage return is treated as
+// any other character.
+func WithNormalizeNewlines() Option {
+	return func(c *config) {
+		c.normalizeNewlines = true
+	}
+}
+
+// WithFirstMatch causes the lexer to prefer, at each position, the
+// first lexeme pattern which matches, rather than the one which
+// produces the longest match. For example, given the patterns "="
+// and "==" in that order, the input "==" is lexed by default as a
+// single "==" token, since that is the longest match, but with this
+// option is lexed as two "=" tokens, since "=" is the first pattern
+// to match. Alternations and repetitions within individual patterns
+// are similarly resolved in favor of the first alternative, as with
+// Perl rather than POSIX regular expressions, so non-greedy
+// repetitions such as "a+?" also behave as they would in Perl.
+func WithFirstMatch() Option {
+	return func(c *config) {
+		c.firstMatch = true
+	}
+}
+
+// WithMaxInputBytes causes the lexer to return an InputTooLargeError,
+// rather than lexing its input, if the input is more than n bytes long.
+// Input of exactly n bytes is lexed as usual. Reading stops as soon
+// as the limit is exceeded, so that a huge input cannot exhaust the
+// available memory. Zero, the default, means there is no limit.
+func WithMaxInputBytes(n int) Option {
+	return func(c *config) {
+		c.maxInputBytes = n
+	}
+}
+
+// WithMaxTokenBytes causes the lexer to return a TokenTooLongError if
+// any lexeme it matches, including those matched by skip patterns, is
+// more than n bytes long. Zero, the default, means there is no limit.
+func WithMaxTokenBytes(n int) Option {
+	return func(c *config) {
+		c.maxTokenBytes = n
+	}
+}
+
+// WithMaxTokens causes the lexer to return a TokenLimitError, along
+// with the tokens found so far, if it finds more than n tokens in the
+// same input, so that an input of many small lexemes cannot exhaust
+// the available memory. Zero, the default, means there is no limit.
+func WithMaxTokens(n int) Option {
+	return func(c *config) {
+		c.maxTokens = n
+	}
+}
+
+// WithFilename causes the lexer to label every MatchError, and the
+// other errors with a position in the input, with the provided name of
+// the input, so that they may be reported in the conventional
+// file:line:column form. The name is the same for every token, so it
+// is kept by the lexer, and returned by Filename, rather than copied
+// into each token. The name is only reported, and is never used to
+// open a file.
+func WithFilename(name string) Option {
+	return func(c *config) {
+		c.filename = name
+	}
+}
+
+// WithRequireFullMatch causes the lexer to return a TrailingInputError
+// if lexing stops without an error before all of the input has been
+// consumed. Input consumed as whitespace or by skip patterns counts as
+// consumed, even though it produces no tokens, so input ending with
+// whitespace or a comment is fully matched. Input which cannot be
+// matched at all is still reported with a MatchError.
+func WithRequireFullMatch() Option {
+	return func(c *config) {
+		c.requireFullMatch = true
+	}
+}
+
+// WithErrorToken causes the lexer to return a token with the ID
+// Unmatched, rather than a MatchError, when it finds input which it
+// cannot match against any of its lexeme patterns, and then continue
+// lexing, so that any input can be lexed without error. The value of
+// the token is the input up to the next rune which is whitespace or at
+// which a pattern matches, or to the end of the input.
+func WithErrorToken() Option {
+	return func(c *config) {
+		c.errorToken = true
+	}
+}
+
+// WithFallbackID causes the lexer to return a token with the provided
+// ID, containing only the next rune, when it finds input which it
+// cannot match against any of its lexeme patterns, rather than a
+// MatchError, and then continue lexing after that rune. The ID must be
+// that of one of the lexeme patterns, conventionally the last, or a
+// FallbackIDError is returned when the lexer is created. Unlike with
+// the WithErrorToken option, which it takes precedence over, each
+// unmatched rune is a separate token, with an ordinary ID.
+func WithFallbackID(id int) Option {
+	return func(c *config) {
+		c.fallback = true
+		c.fallbackID = id
+	}
+}
+
+// WithStepTimeout causes the lexer to return a TimeoutError if finding
+// which pattern matches at any position in the input takes longer than
+// d, such as with a huge number of patterns and adversarial input, or
+// a slow input, to bound the time taken to find each token. Each step
+// is run in a separate goroutine, which adds some overhead to every
+// token, and which is left to finish in the background if the step
+// times out, since it can't be interrupted. Once a step has timed out,
+// its goroutine reads no more of the input, but a read which was
+// already in progress, such as one waiting for a slow input, may still
+// complete after Lex has returned, so the input should not be read
+// from again after a TimeoutError. Zero, the default, means there is
+// no timeout.
+func WithStepTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.stepTimeout = d
+	}
+}
+
+// WithTracer causes the lexer to write a line to w for each step it
+// takes in lexing its input, giving the position at which it found
+// each token, the index of the pattern which matched it, its value and
+// its length in bytes, along with each run of whitespace skipped and
+// any error which stopped lexing, to help diagnose why input is lexed
+// as it is. If the lexer is used concurrently, w must be safe for
+// concurrent use. Nothing is traced by default.
+func WithTracer(w io.Writer) Option {
+	return func(c *config) {
+		c.tracer = w
+	}
+}
+
+// WithStripBOM causes the lexer to skip a UTF-8 byte order mark at the
+// start of its input, rather than failing to match it. The positions
+// of tokens are still those in the original input, so the first token
+// after a byte order mark has an Index of 3, although its Column is 1.
+func WithStripBOM() Option {
+	return func(c *config) {
+		c.stripBOM = true
+	}
+}
+
+// WithInterning causes the lexer to share the storage of tokens with
+// identical values found in the same input, rather than allocating a
+// new string for each, which saves allocations, and the memory held by
+// the tokens, when the same long lexemes, such as paths or quoted
+// strings in log lines, appear many times. Values of a single byte,
+// such as most operators, are never allocated anyway, so there is
+// nothing to save for them. Each value is looked up in a table of
+// every distinct value, which is kept until lexing of the input is
+// complete, so lexing is slower, and this may cost more than it saves
+// if most values are distinct.
+func WithInterning() Option {
+	return func(c *config) {
+		c.interning = true
+	}
+}
+
+// WithStats causes the lexer to count the tokens it finds, how many
+// times each of its patterns is matched, and how many steps it took to
+// match them and how long those took, which may be retrieved with
+// Stats, such as to find patterns which are never matched, or to order
+// patterns for the WithFirstMatch option. Counting and timing have a
+// small cost for each token, so they are not done by default.
+func WithStats() Option {
+	return func(c *config) {
+		c.stats = true
+	}
+}
+
+// WithOptimizedPattern causes the lexer to find lexemes with a combined
+// expression built to be matched quickly, rather than one containing a
+// capturing group for each pattern, and to then identify which pattern
+// matched separately. This can make a lexer with many patterns, such as
+// a large set of keywords, much faster, since the expression can share
+// the work of matching the prefixes that patterns have in common, but
+// it may be slower for a lexer with only a few patterns, each of which
+// is complex. The tokens found are the same either way.
+func WithOptimizedPattern() Option {
+	return func(c *config) {
+		c.optimizedPattern = true
+	}
+}
+
+// WithForcedBoundaries causes the lexer to treat each of the provided
+// runes as a boundary which no token may cross, so that, for example,
+// with ';' as a boundary, the pattern "[^ ]+" matches "a" and then ";"
+// in the input "a;", rather than all of it. When finding the longest
+// match, the patterns only see the input up to the next boundary rune,
+// as if it ended there, so that anchors such as $ and \b match before a
+// boundary rune. A boundary rune is always matched on its own, and so
+// must itself be matched by one of the patterns, as ";" is by "[^ ]+".
+// Boundary runes which are whitespace are skipped as usual. When input
+// is read incrementally, such as by Scan, it is read ahead as far as
+// the next boundary rune.
+func WithForcedBoundaries(runes ...rune) Option {
+	return func(c *config) {
+		c.boundaries = runes
+	}
+}
+
+// WithCollapseNewlines causes the lexer to return a run of consecutive
+// newline tokens, such as those for blank lines, as a single newline
+// token, if the newline character is one of the lexeme patterns. The
+// token has the value "\n" and the Index of the first newline in the
+// run, and its End is that of the last. Whitespace between the newlines
+// is skipped as part of the run, unless the lexer was created with the
+// WithTrivia option, in which case only newlines which are immediately
+// consecutive are collapsed, so that no whitespace token is lost.
+func WithCollapseNewlines() Option {
+	return func(c *config) {
+		c.collapseNewlines = true
+	}
+}
+
+// WithIndentation causes the lexer to produce tokens with the IDs
+// Indent and Dedent as the indentation of the lines of the input
+// changes, for languages in which indentation determines structure.
+// The width of the spaces and tabs before the first token on a line is
+// measured, with each tab reaching the next multiple of 8, and compared
+// with a stack of the widths of the enclosing levels of indentation,
+// which starts with zero. An Indent token precedes the first token on a
+// line indented further than the current level, and a Dedent token for
+// each level closed precedes the first token on a line indented less.
+// If a line is indented less, but not to the width of an enclosing
+// level, an IndentationError is returned. Lines which are blank, or
+// which contain only whitespace and skip patterns, are ignored, as are
+// lines on which a skip pattern precedes the first token. At the end of
+// the input, a Dedent token closes each level still open. Indent and
+// Dedent tokens have empty values, and the position of the token which
+// follows them.
+func WithIndentation() Option {
+	return func(c *config) {
+		c.indentation = true
+	}
+}
+
+// WithNestedComment causes the lexer to skip comments which start with
+// the open delimiter and end with the close delimiter, such as "/*" and
+// "*/", and which may contain other such comments, as in Rust or Swift.
+// No regular expression can match a comment which nests, so the open
+// delimiter is looked for at the start of each token before the
+// patterns are tried, and the input is then consumed, counting each
+// open and close delimiter, until the close delimiter matching the
+// first. Comments are skipped along with whitespace, unless the lexer
+// was also created with the WithTrivia option, in which case each is
+// returned as a token with the ID Comment. If the input ends inside a
+// comment, an UnterminatedCommentError is returned. The option has no
+// effect if either delimiter is empty.
+func WithNestedComment(open, close string) Option {
+	return func(c *config) {
+		c.commentOpen, c.commentClose = open, close
+		if open == "" || close == "" {
+			c.commentOpen, c.commentClose = "", ""
+		}
+	}
+}
+
+// WithStopPattern causes the lexer to stop, without an error, as soon
+// as it finds a token matched by the lexeme pattern with the provided
+// ID, such as a blank line ending the headers of a message, rather than
+// continuing to the end of the input. The token is the last returned
+// if include is true, and isn't returned otherwise. Either way, the
+// input is consumed up to the end of the token, so the number of bytes
+// which LexN reports were consumed is the length of the message up to
+// and including it, and the rest of the input may be lexed separately.
+// No EOF token follows the token, and stopping is not an error even
+// with the WithRequireFullMatch option. Since newlines are skipped as
+// whitespace unless the newline character by itself is one of the
+// lexeme patterns, a stop pattern which starts with a newline, such as
+// "\n\n" for a blank line, only works if "\n" is also a pattern, and
+// otherwise never matches. The ID must be that of one of the lexeme
+// patterns, or a StopPatternError is returned when the lexer is
+// created.
+func WithStopPattern(id int, include bool) Option {
+	return func(c *config) {
+		c.stop, c.stopID, c.stopInclude = true, id, include
+	}
+}
+
+// WithSignificantWhitespace causes the lexer not to skip whitespace
+// before each token, so that the patterns are responsible for matching
+// it, as with a pattern like "[[:alpha:] ]+" for a phrase which may
+// start with a space, or " +" for the spaces between tokens. With it,
+// whitespace which no pattern matches is reported with a MatchError,
+// like any other unmatched input, and the WithWhitespace option, and
+// the whitespace tokens of the WithTrivia option, have no effect. By
+// default, whitespace is skipped.
+func WithSignificantWhitespace() Option {
+	return func(c *config) {
+		c.significantSpace = true
+	}
+}
+
+// WithoutValues causes the lexer to leave the Value of each token
+// empty, other than that of a newline token, rather than allocating a
+// string for it, for callers which keep the input and would rather
+// slice it themselves, as input[token.Start():token.End], which saves
+// an allocation for each token. Keywords are still recognized, and
+// newline tokens keep the value "\n", which costs nothing, so that
+// IsNewline still works, but Submatches returns nil. The Start and
+// End of a token are offsets in runes, rather than bytes, with the
+// WithRuneIndex option. By default, the Value of each token is set.
+func WithoutValues() Option {
+	return func(c *config) {
+		c.withoutValues = true
+	}
+}
+
+// WithFirstByteDispatch causes the lexer to work out, when it is
+// created, the bytes with which a match for each pattern may start, so
+// that at each position it can look at the next byte before matching
+// any expression. If no pattern may start with the byte, there is no
+// match, and if only one may, that pattern is matched on its own, which
+// is much quicker than matching the combined expression, so that input
+// over a small alphabet, such as DNA sequences matched by "[ACGT]+"
+// among a few delimiters, is lexed much faster. Otherwise, and for a
+// pattern whose first byte can't be narrowed down, such as one which
+// starts with ".", which may start with any byte, the combined
+// expression is matched as usual. The tokens found are the same either
+// way. It has no effect if every pattern is a literal string, since
+// those are already matched without the combined expression.
+func WithFirstByteDispatch() Option {
+	return func(c *config) {
+		c.firstByteDispatch = true
+	}
+}
+
+// WithDecoder causes the value of each token identified by the lexeme
+// pattern with the provided ID to be set to the result of calling the
+// function with the text of the lexeme, such as to unescape a string
+// literal with strconv.Unquote, with the text itself kept in the Raw
+// field of the token. If the function returns an error, lexing stops
+// with a DecodeError. The option may be given once for each pattern
+// which needs a decoder, and a later decoder for the same ID replaces
+// an earlier one. Decoders are not called with the WithoutValues
+// option, or for tokens found with the WithFallbackID option.
+func WithDecoder(id int, decode func(raw string) (string, error)) Option {
+	return func(c *config) {
+		decoders := make(map[int]func(string) (string, error))
+		for k, v := range c.decoders {
+			decoders[k] = v
+		}
+		decoders[id] = decode
+		c.decoders = decoders
+	}
+}
+
+// WithGroups causes the lexer to record the text matched by each
+// capturing group of the lexeme pattern which identified a token, from
+// the match made while lexing, so that Submatches returns those groups
+// rather than matching the pattern against the text of the token again.
+// They are then the same as the match even for a pattern whose groups
+// depend on the input following the token, such as one using \b or $.
+// The groups are held by each token, so two tokens with groups are only
+// equal with == if one is a copy of the other, although Equals is not
+// affected. The lexer always matches with the combined expression with
+// this option, so WithOptimizedPattern and WithFirstByteDispatch have no
+// effect, and neither does having only literal patterns.
+func WithGroups() Option {
+	return func(c *config) {
+		c.recordGroups = true
+	}
+}
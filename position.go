@@ -0,0 +1,26 @@
+package lexer
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// LineAt translates a byte index into the input, such as the Index
+// of a Token or MatchError, into a line and a column, both starting
+// at 1. The column is counted in runes since the last newline
+// character before the index. An index equal to the length of the
+// input, as may occur for an error at the end of the input, is valid,
+// and indices outside the input are treated as the nearest valid one.
+func LineAt(input []byte, index int) (line, col int) {
+	if index < 0 {
+		index = 0
+	} else if index > len(input) {
+		index = len(input)
+	}
+
+	before := input[:index]
+	start := bytes.LastIndexByte(before, '\n') + 1
+
+	return bytes.Count(before, []byte{'\n'}) + 1,
+		utf8.RuneCount(before[start:]) + 1
+}
@@ -0,0 +1,36 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+func TestLineAt(t *testing.T) {
+	input := []byte("ab\ncafé x\n\nz")
+
+	testCases := []struct {
+		index int
+		line  int
+		col   int
+	}{
+		{0, 1, 1},
+		{1, 1, 2},
+		{2, 1, 3},
+		{3, 2, 1},
+		{9, 2, 6},
+		{10, 2, 7},
+		{11, 3, 1},
+		{12, 4, 1},
+		{13, 4, 2},
+		{-1, 1, 1},
+		{100, 4, 2},
+	}
+
+	for n, tc := range testCases {
+		line, col := lexer.LineAt(input, tc.index)
+		if line != tc.line || col != tc.col {
+			t.Errorf("case %d, got %d:%d, want %d:%d", n+1,
+				line, col, tc.line, tc.col)
+		}
+	}
+}
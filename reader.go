@@ -0,0 +1,72 @@
+package lexer
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// LexReader returns a reader from which the tokens in the input may be
+// read as text, one line for each token, with its ID, value and index
+// separated by tab characters, as in "0\tabc\t4\n". Any backslash, tab,
+// newline or carriage return in a value is escaped as \\, \t, \n or \r
+// respectively, so that each token is always on one line. The input is
+// lexed lazily, in the same way as with Scan, as lines are read. If
+// lexing stops with an error, the last line is "error", a tab and the
+// escaped error message, after which reading returns the error itself
+// rather than io.EOF.
+func (l *Lexer) LexReader(input io.Reader) io.Reader {
+	return &tokenReader{scanner: l.Scan(input)}
+}
+
+// tokenReader implements io.Reader for LexReader, holding the part of
+// the line for the current token which has yet to be read.
+type tokenReader struct {
+	scanner *TokenScanner
+	line    []byte
+	err     error
+}
+
+// tsvEscaper escapes the characters in values which can't appear in a
+// field of a line of tab-separated values.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`,
+	"\r", `\r`)
+
+// Read reads the rest of the line for the current token into p, or as
+// much of it as will fit. The next token is only found once the line
+// for the current one has been read, so that reading never waits for
+// more input than is needed.
+func (r *tokenReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for len(r.line) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+
+	n := copy(p, r.line)
+	r.line = r.line[n:]
+	return n, nil
+}
+
+// next gets the line for the next token, or for the error which
+// stopped lexing.
+func (r *tokenReader) next() {
+	token, err := r.scanner.Next()
+	if err == io.EOF {
+		r.err = err
+		return
+	} else if err != nil {
+		r.line = []byte("error\t" + tsvEscaper.Replace(err.Error()) + "\n")
+		r.err = err
+		return
+	}
+
+	line := strconv.Itoa(token.ID) + "\t" + tsvEscaper.Replace(token.Value) +
+		"\t" + strconv.Itoa(token.Index) + "\n"
+	r.line = []byte(line)
+}
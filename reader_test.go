@@ -0,0 +1,53 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLexReader(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", `"[^"]*"`})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	testCases := []struct {
+		input string
+		want  string
+		err   bool
+	}{
+		{"", "", false},
+		{"abc def", "0\tabc\t0\n0\tdef\t4\n", false},
+		{"x \"a\tb\\\nc\"", "0\tx\t0\n1\t\"a\\tb\\\\\\nc\"\t2\n", false},
+		{
+			"ab ?",
+			"0\tab\t0\n" +
+				"error\tcouldn't match input at line 1, column 4: \"?\"\n",
+			true,
+		},
+	}
+
+	for n, tc := range testCases {
+		for _, reader := range []func(io.Reader) io.Reader{
+			nil, iotest.OneByteReader,
+		} {
+			r := l.LexReader(strings.NewReader(tc.input))
+			if reader != nil {
+				r = reader(r)
+			}
+
+			got, err := ioutil.ReadAll(r)
+			if string(got) != tc.want {
+				t.Errorf("case %d, got %q, want %q", n+1, got, tc.want)
+			}
+			if _, ok := err.(lexer.MatchError); ok != tc.err {
+				t.Errorf("case %d, got error %v, want MatchError %t",
+					n+1, err, tc.err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package lexer
+
+import (
+	"io"
+	"time"
+)
+
+// LexResult holds everything LexDetailed finds out about an input, for
+// tools which want more than the tokens. More fields may be added in
+// future, so a LexResult should only be read by field name.
+type LexResult struct {
+	// Tokens is the list of tokens found, up to any error which
+	// stopped lexing.
+	Tokens TokenList
+	// MatchErrors holds a MatchError for each region of the input
+	// which couldn't be matched, in the order in which they were
+	// found, as for LexRecover.
+	MatchErrors []MatchError
+	// Err is the error which stopped lexing before the end of the
+	// input, if any, which is never a MatchError.
+	Err Error
+	// Bytes is the number of bytes of the input consumed.
+	Bytes int
+	// Lines is the number of lines of the input consumed, including
+	// a last line without a newline.
+	Lines int
+	// Duration is how long lexing took, including reading the input.
+	Duration time.Duration
+}
+
+// LexDetailed lexically analyses the input in the same way as
+// LexRecover, skipping input which can't be matched rather than
+// stopping, and returns the tokens along with the errors encountered,
+// how much of the input was consumed, and how long it took.
+func (l *Lexer) LexDetailed(input io.Reader) LexResult {
+	start := time.Now()
+	result := LexResult{Tokens: TokenList{}}
+
+	buffer, err := l.newBuffer(input)
+	if err != nil {
+		result.Err = err
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	result.Err = l.lexBuffer(buffer, func(token Token) Error {
+		result.Tokens = append(result.Tokens, token)
+		return nil
+	}, func(err MatchError) {
+		result.MatchErrors = append(result.MatchErrors, err)
+	})
+
+	result.Bytes = buffer.discarded + buffer.index
+	result.Lines = buffer.line - 1
+	if buffer.column > 1 {
+		result.Lines++
+	}
+	result.Duration = time.Since(start)
+	return result
+}
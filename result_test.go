@@ -0,0 +1,86 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestLexDetailed(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	testCases := []struct {
+		input  string
+		want   lexer.TokenList
+		errors []int
+		bytes  int
+		lines  int
+	}{
+		{"", lexer.TokenList{}, nil, 0, 0},
+		{"ab 12\n", lexer.TokenList{
+			{ID: 0, Value: "ab", Index: 0},
+			{ID: 1, Value: "12", Index: 3},
+		}, nil, 6, 1},
+		{"ab ?? 12\n$ cd", lexer.TokenList{
+			{ID: 0, Value: "ab", Index: 0},
+			{ID: 1, Value: "12", Index: 6},
+			{ID: 0, Value: "cd", Index: 11},
+		}, []int{3, 9}, 13, 2},
+	}
+
+	for n, tc := range testCases {
+		result := l.LexDetailed(strings.NewReader(tc.input))
+		if result.Err != nil {
+			t.Errorf("case %d, got error %v", n+1, result.Err)
+		}
+		if diff := result.Tokens.Diff(tc.want); diff != "" {
+			t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+		}
+		var errors []int
+		for _, merr := range result.MatchErrors {
+			errors = append(errors, merr.Index)
+		}
+		if len(errors) != len(tc.errors) {
+			t.Errorf("case %d, got match errors at %v, want %v", n+1,
+				errors, tc.errors)
+		} else {
+			for i := range errors {
+				if errors[i] != tc.errors[i] {
+					t.Errorf("case %d, got match errors at %v, want %v",
+						n+1, errors, tc.errors)
+					break
+				}
+			}
+		}
+		if result.Bytes != tc.bytes || result.Lines != tc.lines {
+			t.Errorf("case %d, got %d bytes and %d lines, want %d and %d",
+				n+1, result.Bytes, result.Lines, tc.bytes, tc.lines)
+		}
+		if result.Duration < 0 {
+			t.Errorf("case %d, got duration %v", n+1, result.Duration)
+		}
+	}
+
+	// An error reading the input stops lexing, and is reported along
+	// with whatever was found before it.
+
+	l, err = lexer.New([]string{"[[:alpha:]]+"}, lexer.WithBufferSize(6))
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	result := l.LexDetailed(iotest.TimeoutReader(strings.NewReader("a b c defg")))
+	if _, ok := result.Err.(lexer.InputError); !ok {
+		t.Errorf("got error %v, want InputError", result.Err)
+	}
+	want := lexer.TokenList{
+		{ID: 0, Value: "a", Index: 0},
+		{ID: 0, Value: "b", Index: 2},
+	}
+	if diff := result.Tokens.Diff(want); diff != "" {
+		t.Errorf("got unexpected tokens:\n%s", diff)
+	}
+}
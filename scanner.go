@@ -0,0 +1,137 @@
+package lexer
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// defaultBufferSize is the number of bytes a scanner reads from
+// its input at a time, unless the lexer was created with the
+// WithBufferSize option.
+const defaultBufferSize = 4096
+
+// TokenScanner provides tokens one at a time with one token of
+// lookahead and one token of pushback, reading its input
+// incrementally as more tokens are requested.
+type TokenScanner struct {
+	lexer   *Lexer
+	buffer  *indexedBuffer
+	pending []Token
+	last    Token
+	hasLast bool
+	err     error
+}
+
+// Scan returns a scanner which lexically analyses the input one
+// token at a time, reading only as much of it as is needed to
+// find each token.
+func (l *Lexer) Scan(input io.Reader) *TokenScanner {
+	size := l.bufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+
+	return &TokenScanner{
+		lexer:  l,
+		buffer: newWindowedBuffer(l.limit(input), size, &l.config),
+	}
+}
+
+// ScanRunes returns a scanner which lexically analyses runes read
+// from the input one token at a time, in the same way as Scan. Runes
+// are only read from the input as they are needed to find each token,
+// along with the few beyond it which the regular expression engine
+// needs to see to be sure the token has ended, so the input may
+// produce them lazily, such as when they are typed by a user. The runes
+// are kept, encoded as UTF-8, only until the tokens containing them
+// have been found, so the input need not fit in memory. Any
+// io.RuneScanner may be used, but UnreadRune is never called.
+func (l *Lexer) ScanRunes(input io.RuneReader) *TokenScanner {
+	return l.Scan(&runeSource{runes: input})
+}
+
+// Next returns the next token and advances the scanner past it. At
+// the end of the input, io.EOF is returned. If any other error is
+// returned, such as a MatchError, that same error will be returned
+// from all subsequent calls.
+func (s *TokenScanner) Next() (Token, error) {
+	token, err := s.Peek()
+	if err == nil {
+		s.pending = s.pending[:len(s.pending)-1]
+		s.last, s.hasLast = token, true
+	}
+	return token, err
+}
+
+// Peek returns the next token without advancing the scanner past
+// it, so that repeated calls to Peek, and the subsequent call to
+// Next, return the same token. Errors are returned as for Next.
+func (s *TokenScanner) Peek() (Token, error) {
+	if len(s.pending) > 0 {
+		return s.pending[len(s.pending)-1], nil
+	}
+	if s.err != nil {
+		return Token{}, s.err
+	}
+
+	token, ok, err := s.lexer.scan(s.buffer)
+	if err != nil {
+		s.err = err
+		return Token{}, err
+	}
+	if !ok {
+		s.err = io.EOF
+		return Token{}, io.EOF
+	}
+
+	s.pending = append(s.pending, token)
+	return token, nil
+}
+
+// Unread moves the scanner back before the token most recently
+// returned by Next, so that the next call to Peek or Next returns it
+// again, even if the end of the input or an error has since been
+// reached. Only one token may be unread before Next is called again,
+// and an UnreadError is returned if there is no token to unread.
+func (s *TokenScanner) Unread() Error {
+	if !s.hasLast {
+		return newUnreadError()
+	}
+	s.pending = append(s.pending, s.last)
+	s.hasLast = false
+	return nil
+}
+
+// runeSource implements io.Reader over an io.RuneReader, encoding
+// each rune it reads as UTF-8. It reads only one rune for each call
+// to Read, so that it never waits for more runes than are needed. The
+// runes are encoded rather than matched as they are, since the regular
+// expressions can only be matched from a position in bytes or from a
+// reader which can't be rewound, and the positions of tokens, and the
+// windowed buffer which holds the input still to be lexed, are all in
+// bytes of UTF-8 too.
+type runeSource struct {
+	runes   io.RuneReader
+	pending []byte
+}
+
+// Read reads the encoding of the next rune into p, or as much of the
+// encoding as fits, keeping the rest for the next call.
+func (r *runeSource) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if len(r.pending) == 0 {
+		c, _, err := r.runes.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		var encoded [utf8.UTFMax]byte
+		r.pending = encoded[:utf8.EncodeRune(encoded[:], c)]
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
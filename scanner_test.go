@@ -0,0 +1,196 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScannerNextAndPeek(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	s := l.Scan(strings.NewReader("abc 123 def"))
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc", Index: 0},
+		lexer.Token{ID: 1, Value: "123", Index: 4},
+		lexer.Token{ID: 0, Value: "def", Index: 8},
+	}
+
+	for n, w := range want {
+		for i := 0; i < 2; i++ {
+			if token, err := s.Peek(); err != nil {
+				t.Fatalf("case %d, couldn't peek token: %v", n+1, err)
+			} else if !token.Equals(w) {
+				t.Errorf("case %d, peeked %v, want %v", n+1, token, w)
+			}
+		}
+
+		if token, err := s.Next(); err != nil {
+			t.Fatalf("case %d, couldn't get token: %v", n+1, err)
+		} else if !token.Equals(w) {
+			t.Errorf("case %d, got %v, want %v", n+1, token, w)
+		}
+	}
+
+	if _, err := s.Peek(); err != io.EOF {
+		t.Errorf("got %v from Peek, want io.EOF", err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("got %v from Next, want io.EOF", err)
+	}
+}
+
+func TestScannerMatchError(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	s := l.Scan(strings.NewReader("abc ?"))
+
+	if _, err := s.Next(); err != nil {
+		t.Fatalf("couldn't get token: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Next(); err == nil {
+			t.Errorf("case %d, input unexpectedly matched", i+1)
+		} else if merr, ok := err.(lexer.MatchError); !ok {
+			t.Errorf("case %d, error of unexpected type", i+1)
+		} else if merr.Index != 4 {
+			t.Errorf("case %d, got %d, want %d", i+1, merr.Index, 4)
+		}
+	}
+}
+
+func TestScannerEOFToken(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"}, lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	s := l.Scan(strings.NewReader("abc"))
+
+	if token, err := s.Next(); err != nil || token.ID != 0 {
+		t.Errorf("got %v, %v, want token with id 0", token, err)
+	}
+	if token, err := s.Next(); err != nil || token.ID != lexer.EOF ||
+		token.Index != 3 {
+		t.Errorf("got %v, %v, want EOF token at index 3", token, err)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestScannerUnread(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	s := l.Scan(strings.NewReader("abc 123"))
+
+	if err := s.Unread(); err == nil {
+		t.Errorf("unread before Next, got no error, want UnreadError")
+	} else if _, ok := err.(lexer.UnreadError); !ok {
+		t.Errorf("got error %v, want UnreadError", err)
+	}
+
+	abc := lexer.Token{ID: 0, Value: "abc", Index: 0}
+	num := lexer.Token{ID: 1, Value: "123", Index: 4}
+
+	// Unreading a token puts it back ahead of a peeked token.
+
+	if token, err := s.Next(); err != nil || !token.Equals(abc) {
+		t.Errorf("got %v, %v, want %v", token, err, abc)
+	}
+	if token, err := s.Peek(); err != nil || !token.Equals(num) {
+		t.Errorf("got %v, %v, want %v", token, err, num)
+	}
+	if err := s.Unread(); err != nil {
+		t.Errorf("couldn't unread token: %v", err)
+	}
+	if err := s.Unread(); err == nil {
+		t.Errorf("unread twice, got no error, want UnreadError")
+	}
+
+	want := lexer.TokenList{abc, num}
+	for n, w := range want {
+		if token, err := s.Next(); err != nil || !token.Equals(w) {
+			t.Errorf("case %d, got %v, %v, want %v", n+1, token, err, w)
+		}
+	}
+
+	// A token may still be unread after the end of the input.
+
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+	if err := s.Unread(); err != nil {
+		t.Errorf("couldn't unread token: %v", err)
+	}
+	if token, err := s.Next(); err != nil || !token.Equals(num) {
+		t.Errorf("got %v, %v, want %v", token, err, num)
+	}
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+// countingRuneReader reads runes from a string, counting how many
+// have been read.
+type countingRuneReader struct {
+	runes []rune
+	read  int
+}
+
+func (r *countingRuneReader) ReadRune() (rune, int, error) {
+	if r.read >= len(r.runes) {
+		return 0, 0, io.EOF
+	}
+	c := r.runes[r.read]
+	r.read++
+	return c, len(string(c)), nil
+}
+
+func TestScannerRunes(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+", "é"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := &countingRuneReader{runes: []rune("abc é 123 def")}
+	s := l.ScanRunes(input)
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc", Index: 0},
+		lexer.Token{ID: 2, Value: "é", Index: 4},
+		lexer.Token{ID: 1, Value: "123", Index: 7},
+		lexer.Token{ID: 0, Value: "def", Index: 11},
+	}
+
+	for n, w := range want {
+		if token, err := s.Next(); err != nil {
+			t.Fatalf("case %d, couldn't get token: %v", n+1, err)
+		} else if !token.Equals(w) {
+			t.Errorf("case %d, got %v, want %v", n+1, token, w)
+		}
+
+		// The regular expression engine reads a few runes ahead of
+		// the end of each token, but no more than that.
+
+		if n == 0 && input.read == len(input.runes) {
+			t.Errorf("case %d, all runes read after first token", n+1)
+		}
+	}
+
+	if _, err := s.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,101 @@
+package lexer
+
+// Splice returns the list of tokens for an input which has been edited
+// since the list was found, by lexing again only as much of the input
+// as the edit might have changed, rather than all of it, such as in an
+// editor keeping the tokens of a large file up to date as it is typed.
+// The list must have been found by the lexer from the input before the
+// edit, and input is the whole input after it, in which the bytes from
+// changedStart up to changedEnd in the old input were replaced with the
+// newLen bytes from changedStart. The list itself is not modified.
+//
+// Lexing starts again from the start of the token before the first
+// which ends at or after changedStart, since the edit may extend it or
+// join it to the next, and continues until it finds a token after the
+// edit which is one of the old tokens moved by the edit, from which
+// point the rest of the old tokens are kept, with their positions
+// adjusted. The tokens are found again from the middle of the input in
+// the same way as with LexFrom, so the lexer must not be modal, and
+// must not have been created with the WithIndentation or WithRuneIndex
+// options.
+// An EditRangeError is returned if the edit doesn't fit the input. If
+// lexing stops with an error, the list returned with it contains every
+// token found before the error was encountered.
+func (t TokenList) Splice(l *Lexer, input []byte, changedStart, changedEnd,
+	newLen int) (TokenList, Error) {
+	if changedStart < 0 || changedEnd < changedStart || newLen < 0 ||
+		changedStart+newLen > len(input) {
+		return nil, newEditRangeError(changedStart, changedEnd, newLen,
+			len(input))
+	}
+	delta := newLen - (changedEnd - changedStart)
+
+	restart := 0
+	for restart < len(t) && t[restart].End < changedStart {
+		restart++
+	}
+	if restart > 0 {
+		restart--
+	}
+
+	list := append(TokenList{}, t[:restart]...)
+	b := newIndexedBuffer(input, &l.config)
+	if restart < len(t) {
+		b.advance(t[restart].Index)
+		b.bomDone = t[restart].Index > 0
+	}
+
+	old := restart
+	for {
+		token, ok, err := l.scan(b)
+		if err != nil {
+			return list, err
+		} else if !ok {
+			return list, nil
+		}
+		list = append(list, token)
+
+		// Once we find a token after the edit which is one of the old
+		// tokens moved, lexing from there would find the rest of the
+		// old tokens again, so we can keep them instead.
+
+		if token.Index < changedStart+newLen {
+			continue
+		}
+		for old < len(t) && t[old].Index+delta < token.Index {
+			old++
+		}
+		if old < len(t) && moved(t[old], delta) == moved(token, 0) {
+			return append(list, shifted(t[old+1:], t[old], token)...), nil
+		}
+	}
+}
+
+// moved returns a token with only the fields which Splice compares,
+// with its positions moved by delta bytes.
+func moved(token Token, delta int) Token {
+	return Token{ID: token.ID, Value: token.Value, Index: token.Index + delta,
+		End: token.End + delta, Sub: token.Sub, Raw: token.Raw}
+}
+
+// shifted returns a copy of the tokens which followed a token before
+// an edit, with their positions moved in the same way as that token's
+// were to give the token after the edit. Only the columns of the tokens
+// on the same line as the token are changed, since the start of every
+// later line moves with it.
+func shifted(tokens TokenList, before, after Token) TokenList {
+	delta := after.Index - before.Index
+	lines, columns := after.Line-before.Line, after.Column-before.Column
+
+	list := make(TokenList, len(tokens))
+	for i, token := range tokens {
+		if token.Line == before.Line {
+			token.Column += columns
+		}
+		token.Index += delta
+		token.End += delta
+		token.Line += lines
+		list[i] = token
+	}
+	return list
+}
@@ -0,0 +1,77 @@
+package lexer
+
+import "bufio"
+
+// SplitFunc returns a split function with which a bufio.Scanner splits
+// its input into the values of the tokens which the lexer finds in it,
+// skipping whitespace and any skip patterns in the same way as Lex,
+// along with a function which returns the whole of the token whose
+// value the scanner most recently returned, since a split function can
+// only return bytes. When a token may continue beyond the data which
+// the scanner has read so far, the split function asks it for more,
+// so that tokens are found just as they would be by Lex however the
+// input is read. No EOF token is returned, and the values of the
+// tokens are set even with the WithoutValues option. Any error the
+// lexer encounters, such as a MatchError, stops the scanner, and is
+// returned by its Err method. The functions hold the position in the
+// input, so a new pair is needed for each scanner.
+func (l *Lexer) SplitFunc() (bufio.SplitFunc, func() Token) {
+	split := *l
+	split.eofToken, split.withoutValues = false, false
+	b := newIndexedBuffer(nil, &split.config)
+	b.noDiscard = true
+
+	var last Token
+	fn := func(data []byte, atEOF bool) (int, []byte, error) {
+
+		// The scanner discards the data up to the end of the last
+		// token we returned, so data starts where that token ended.
+		// If we run out of data before we've found the next token,
+		// the buffer is restored, so that we start from the same
+		// place once the scanner has read more.
+
+		saved := *b
+		b.discarded += b.index
+		b.buffer, b.index = data, 0
+		b.reader, b.chunkSize = nil, 0
+		if !atEOF {
+			b.reader, b.chunkSize = moreInputReader{}, 1
+		}
+
+		token, ok, err := split.scan(b)
+		if _, more := err.(moreInputError); more {
+			*b = saved
+			return 0, nil, nil
+		} else if err != nil {
+			return 0, nil, err
+		} else if !ok {
+			return 0, nil, nil
+		}
+
+		last = token
+		return b.index, []byte(token.Value), nil
+	}
+
+	return fn, func() Token { return last }
+}
+
+// moreInputReader implements io.Reader for a split function, making
+// any attempt to read more input than the scanner has provided fail
+// with a moreInputError.
+type moreInputReader struct{}
+
+// Read fails with a moreInputError.
+func (moreInputReader) Read(p []byte) (int, error) {
+	return 0, moreInputError{}
+}
+
+// moreInputError is returned by moreInputReader, and is never returned
+// to the caller of a split function, which is instead asked for more
+// data.
+type moreInputError struct{}
+
+func (e moreInputError) Error() string {
+	return "more input needed"
+}
+
+func (e moreInputError) implementsError() {}
@@ -0,0 +1,82 @@
+package lexer_test
+
+import (
+	"bufio"
+	"github.com/paulgriffiths/lexer"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSplitFunc(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+		err     bool
+	}{
+		{nil, "", false},
+		{nil, "   \n ", false},
+		{nil, "abc 12 <= <\n de<<=f ", false},
+		{nil, "abcd <=", false},
+		{[]lexer.Option{lexer.WithRuneIndex()}, "été 1\n ça", false},
+		{[]lexer.Option{lexer.WithTrivia(), lexer.WithEOFToken()},
+			" a  1 ", false},
+		{[]lexer.Option{lexer.WithSkipPatterns("#[^\n]*")},
+			"a # comment\n1", false},
+		{nil, "abc ? 12", true},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{`[[:alpha:]\p{L}]+`, "[[:digit:]]+",
+			"<", "<=", "<<="}, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		want, werr := l.LexString(tc.input)
+		if len(want) > 0 && want[len(want)-1].ID == lexer.EOF {
+			want = want[:len(want)-1]
+		}
+
+		for _, reader := range []func(io.Reader) io.Reader{
+			nil, iotest.OneByteReader,
+		} {
+			var r io.Reader = strings.NewReader(tc.input)
+			if reader != nil {
+				r = reader(r)
+			}
+
+			scanner := bufio.NewScanner(r)
+			split, last := l.SplitFunc()
+			scanner.Split(split)
+
+			var tokens lexer.TokenList
+			for scanner.Scan() {
+				token := last()
+				if scanner.Text() != token.Value {
+					t.Errorf("case %d, got text %q for token %v", n+1,
+						scanner.Text(), token)
+				}
+				tokens = append(tokens, token)
+			}
+			if diff := tokens.Diff(want); diff != "" {
+				t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+			}
+			for i := range tokens {
+				if i < len(want) && tokens[i] != want[i] {
+					t.Errorf("case %d, got %+v, want %+v", n+1, tokens[i],
+						want[i])
+				}
+			}
+
+			err := scanner.Err()
+			if merr, ok := err.(lexer.MatchError); ok != tc.err {
+				t.Errorf("case %d, got error %v, want MatchError %t",
+					n+1, err, tc.err)
+			} else if ok && merr.Index != werr.(lexer.MatchError).Index {
+				t.Errorf("case %d, got error %v, want %v", n+1, err, werr)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package lexer
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats holds counts of what a lexer created with the WithStats option
+// has found, across every input it has lexed since it was created or
+// since ResetStats was last called.
+type Stats struct {
+	// Tokens is the number of tokens found, including any EOF,
+	// whitespace or Unmatched tokens.
+	Tokens int
+	// Matches maps the ID of each pattern which has been matched to
+	// the number of times it was matched. The IDs of skip patterns
+	// follow those of the lexeme patterns, as for the error types.
+	Matches map[int]int
+	// Steps is the number of times the lexer tried to match its
+	// patterns at some position in the input, including any attempts
+	// at which no pattern matched.
+	Steps int
+	// Duration is the total time spent trying to match the patterns,
+	// so that Duration divided by Steps is roughly how expensive each
+	// step was.
+	Duration time.Duration
+}
+
+// statsCounter accumulates the counts for Stats, which may be updated
+// by any number of inputs being lexed concurrently.
+type statsCounter struct {
+	mu       sync.Mutex
+	tokens   int
+	matches  map[int]int
+	steps    int
+	duration time.Duration
+}
+
+// MatchesSorted returns the number of times each pattern was matched
+// in the same way as Matches, but as a slice sorted by ID, so that the
+// order is the same every time.
+func (s Stats) MatchesSorted() []IDCount {
+	return sortedCounts(s.Matches)
+}
+
+// token counts a token which was found.
+func (c *statsCounter) token() {
+	c.mu.Lock()
+	c.tokens++
+	c.mu.Unlock()
+}
+
+// match counts a match of the pattern with the provided id.
+func (c *statsCounter) match(id int) {
+	c.mu.Lock()
+	c.matches[id]++
+	c.mu.Unlock()
+}
+
+// step counts an attempt to match the patterns which took d.
+func (c *statsCounter) step(d time.Duration) {
+	c.mu.Lock()
+	c.steps++
+	c.duration += d
+	c.mu.Unlock()
+}
+
+// reset sets the counts back to zero.
+func (c *statsCounter) reset() {
+	c.mu.Lock()
+	c.tokens, c.steps, c.duration = 0, 0, 0
+	c.matches = make(map[int]int)
+	c.mu.Unlock()
+}
+
+// add adds the counts so far to stats.
+func (c *statsCounter) add(stats *Stats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats.Tokens += c.tokens
+	for id, n := range c.matches {
+		stats.Matches[id] += n
+	}
+	stats.Steps += c.steps
+	stats.Duration += c.duration
+}
+
+// Stats returns the counts of tokens and pattern matches found by the
+// lexer since it was created, or since ResetStats was last called, if
+// it was created with the WithStats option, or an empty Stats
+// otherwise. Since a lexer may lex any
+// number of inputs concurrently, the counts are for all of them. For a
+// modal lexer, the counts for patterns with the same ID in different
+// modes are combined. Lexers created with With or Without have their
+// own counts.
+func (l *Lexer) Stats() Stats {
+	stats := Stats{Matches: map[int]int{}}
+	if l.stats != nil {
+		l.stats.add(&stats)
+	}
+	for _, modeLexer := range l.modes {
+		if modeLexer.stats != nil {
+			modeLexer.stats.add(&stats)
+		}
+	}
+	return stats
+}
+
+// ResetStats sets the counts returned by Stats back to zero, including
+// those of every mode of a modal lexer, so that the counts for a single
+// input may be found by calling it before lexing the input. Any input
+// being lexed concurrently is counted from the point at which it is
+// called. It has no effect if the lexer was not created with the
+// WithStats option.
+func (l *Lexer) ResetStats() {
+	if l.stats != nil {
+		l.stats.reset()
+	}
+	for _, modeLexer := range l.modes {
+		if modeLexer.stats != nil {
+			modeLexer.stats.reset()
+		}
+	}
+}
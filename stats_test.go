@@ -0,0 +1,112 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+", `\+`},
+		lexer.WithStats(), lexer.WithSkipPatterns("#[^\n]*"),
+		lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if stats := l.Stats(); stats.Tokens != 0 || len(stats.Matches) != 0 ||
+		stats.Steps != 0 || stats.Duration != 0 {
+		t.Errorf("got stats %v before lexing, want none", stats)
+	}
+
+	for _, input := range []string{"a + b # c", "1 + 2 + 3"} {
+		if _, err := l.LexString(input); err != nil {
+			t.Fatalf("couldn't lex input %q: %v", input, err)
+		}
+	}
+
+	want := lexer.Stats{
+		Tokens:  10,
+		Matches: map[int]int{0: 2, 1: 3, 2: 3, 3: 1},
+		Steps:   9,
+	}
+	stats := l.Stats()
+	if stats.Duration <= 0 {
+		t.Errorf("got duration %v, want more than zero", stats.Duration)
+	}
+	stats.Duration = 0
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got stats %v, want %v", stats, want)
+	}
+	wantSorted := []lexer.IDCount{{ID: 0, Count: 2}, {ID: 1, Count: 3},
+		{ID: 2, Count: 3}, {ID: 3, Count: 1}}
+	if sorted := l.Stats().MatchesSorted(); !reflect.DeepEqual(sorted,
+		wantSorted) {
+		t.Errorf("got sorted matches %v, want %v", sorted, wantSorted)
+	}
+
+	// After a reset, only the next input is counted.
+
+	l.ResetStats()
+	if stats := l.Stats(); stats.Tokens != 0 || len(stats.Matches) != 0 ||
+		stats.Steps != 0 || stats.Duration != 0 {
+		t.Errorf("got stats %v after reset, want none", stats)
+	}
+	if _, err := l.LexString("a 1"); err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+	want = lexer.Stats{Tokens: 3, Matches: map[int]int{0: 1, 1: 1},
+		Steps: 2}
+	stats = l.Stats()
+	stats.Duration = 0
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got stats %v after reset, want %v", stats, want)
+	}
+
+	// A lexer created without the option counts nothing.
+
+	l, err = lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	if _, err := l.LexString("a b"); err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+	if stats := l.Stats(); stats.Tokens != 0 || len(stats.Matches) != 0 {
+		t.Errorf("got stats %v without option, want none", stats)
+	}
+}
+
+func TestStatsModal(t *testing.T) {
+	l, err := lexer.NewModal(map[string][]string{
+		lexer.DefaultMode: {"[a-z]+", `\(`},
+		"paren":           {"[0-9]+", `\)`},
+	}, map[string][]lexer.Action{
+		lexer.DefaultMode: {{}, lexer.Push("paren")},
+		"paren":           {{}, lexer.Pop()},
+	}, lexer.WithStats())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	if _, err := l.LexString("f(1) g(2)"); err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	want := lexer.Stats{
+		Tokens:  8,
+		Matches: map[int]int{0: 4, 1: 4},
+		Steps:   8,
+	}
+	stats := l.Stats()
+	stats.Duration = 0
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got stats %v, want %v", stats, want)
+	}
+
+	l.ResetStats()
+	if stats := l.Stats(); stats.Tokens != 0 || len(stats.Matches) != 0 ||
+		stats.Steps != 0 || stats.Duration != 0 {
+		t.Errorf("got stats %v after reset, want none", stats)
+	}
+}
@@ -0,0 +1,327 @@
+package lexer
+
+import (
+	"regexp/syntax"
+	"sync"
+	"unicode/utf8"
+)
+
+// StreamLexer lexically analyses input which arrives in pieces, such
+// as the lines typed into an interactive shell, returning the tokens
+// found so far each time more input arrives, and reporting whether the
+// input ends part of the way through a token, so that the shell knows
+// to prompt for a continuation.
+type StreamLexer struct {
+	lexer  *Lexer
+	buffer *indexedBuffer
+	err    Error
+}
+
+// Stream returns a stream lexer which lexically analyses the input fed
+// to it in the same way as Lex. The values of the tokens are always
+// set, even if the lexer was created with the WithoutValues option,
+// since they are needed to tell where each token starts.
+func (l *Lexer) Stream() *StreamLexer {
+	valued := *l
+	valued.withoutValues = false
+	stream := &StreamLexer{
+		lexer:  &valued,
+		buffer: newIndexedBuffer(nil, &valued.config),
+	}
+	stream.buffer.noDiscard = true
+	return stream
+}
+
+// Feed adds data to the end of the input. The data is copied, so it
+// may be reused as soon as Feed returns.
+func (s *StreamLexer) Feed(data []byte) {
+	b := s.buffer
+	n := copy(b.buffer, b.buffer[b.index:])
+	b.buffer = append(b.buffer[:n], data...)
+	b.discarded += b.index
+	b.index = 0
+}
+
+// Drain returns the tokens found in the input fed so far which haven't
+// already been returned, and true if the input ends with the start of
+// a token which more input could complete or extend, such as an
+// unterminated string literal, or an identifier which may continue. A
+// token is only returned once no more input could change it, so the
+// input from the start of the last token, if it might continue, is
+// kept until more is fed. If an error is encountered, such as a
+// MatchError, the tokens found before it are returned, and Err
+// returns the error, after which Drain returns no more tokens.
+func (s *StreamLexer) Drain() (TokenList, bool) {
+	list := TokenList{}
+	if s.err != nil {
+		return list, false
+	}
+
+	b := s.buffer
+	for {
+		saved := *b
+		b.reader, b.chunkSize = moreInputReader{}, 1
+		token, ok, err := s.lexer.scan(b)
+		b.reader, b.chunkSize = nil, 0
+
+		if _, more := err.(moreInputError); more {
+			*b = saved
+			if s.pending() {
+				return list, true
+			}
+
+			// We ran out of input before we could be sure of the
+			// next token, but no pattern can match beyond the end
+			// of the input, so the token is one which is already
+			// there, or there is none, unless the token we find
+			// could itself be continued.
+
+			token, ok, err := s.lexer.scan(b)
+			if err != nil {
+				_, isMatch := err.(MatchError)
+				_, isComment := err.(UnterminatedCommentError)
+				if (isMatch && s.pending()) || isComment {
+					*b = saved
+					return list, true
+				}
+				s.err = err
+				return list, false
+			} else if !ok || token.ID == EOF {
+				b.finished = false
+				return list, false
+			}
+
+			// A run of whitespace or unmatched input which reaches the
+			// end of the input could be extended by more of it.
+
+			start := *b
+			start.index -= len(token.text())
+			if s.prefix().viable(start.next()) ||
+				((token.ID == Whitespace || token.ID == Unmatched) &&
+					b.index == len(b.buffer)) {
+				*b = saved
+				return list, true
+			}
+
+			list = append(list, token)
+			continue
+		} else if err != nil {
+			s.err = err
+			return list, false
+		} else if !ok {
+			return list, false
+		}
+
+		list = append(list, token)
+	}
+}
+
+// pending checks if the input from the current position, after any
+// whitespace, could be continued by more input to match a pattern, or
+// to open a nested comment.
+func (s *StreamLexer) pending() bool {
+	b := *s.buffer
+	if err := b.skipWhitespace(s.lexer.skipNewline,
+		s.lexer.isSpace); err != nil {
+		return false
+	}
+	rest, open := b.next(), s.lexer.commentOpen
+	if len(rest) > 0 && len(rest) < len(open) &&
+		open[:len(rest)] == string(rest) {
+		return true
+	}
+	return len(rest) > 0 && s.prefix().viable(rest)
+}
+
+// Close marks the end of the input, and returns the tokens found in
+// the rest of the input, including any EOF token, or the first error
+// encountered, in the same way as Lex.
+func (s *StreamLexer) Close() (TokenList, Error) {
+	list := TokenList{}
+	if s.err != nil {
+		return list, s.err
+	}
+
+	for {
+		token, ok, err := s.lexer.scan(s.buffer)
+		if err != nil {
+			s.err = err
+			return list, err
+		} else if !ok {
+			return list, nil
+		}
+		list = append(list, token)
+	}
+}
+
+// Err returns the error which stopped Drain or Close, if any.
+func (s *StreamLexer) Err() Error {
+	return s.err
+}
+
+// prefix returns the prefix matcher for the patterns of the lexer, or
+// of its current mode if it is modal.
+func (s *StreamLexer) prefix() *prefixMatcher {
+	l := s.lexer
+	if l.modes != nil {
+		mode := DefaultMode
+		if len(s.buffer.modes) > 0 {
+			mode = s.buffer.modes[len(s.buffer.modes)-1]
+		}
+		l = l.modes[mode]
+	}
+	return l.prefixMatcher()
+}
+
+// lazyPrefixMatcher holds the prefix matcher for the patterns of a
+// lexer, which is only created the first time it is needed, since
+// most lexers never need it.
+type lazyPrefixMatcher struct {
+	once    sync.Once
+	matcher *prefixMatcher
+}
+
+// prefixMatcher returns the prefix matcher for the lexeme and skip
+// patterns of the lexer, creating it the first time it is needed.
+func (l *Lexer) prefixMatcher() *prefixMatcher {
+	l.prefixes.once.Do(func() {
+		l.prefixes.matcher = newPrefixMatcher(
+			append(l.lexemes[:len(l.lexemes):len(l.lexemes)],
+				l.skipPatterns...), l.caseInsensitive)
+	})
+	return l.prefixes.matcher
+}
+
+// prefixMatcher tells whether some input is the start of a match for
+// any of a set of patterns which could continue with more input. The
+// regular expression engine can't tell us this, so we run the compiled
+// program for each pattern over the input ourselves.
+type prefixMatcher struct {
+	progs []*syntax.Prog
+}
+
+// newPrefixMatcher creates a prefix matcher for the provided patterns,
+// which must already have been checked to be valid.
+func newPrefixMatcher(patterns []string,
+	caseInsensitive bool) *prefixMatcher {
+	flags := syntax.Perl
+	if caseInsensitive {
+		flags |= syntax.FoldCase
+	}
+
+	m := &prefixMatcher{}
+	for _, pattern := range patterns {
+		parsed, err := syntax.Parse(pattern, flags)
+		if err != nil {
+			continue
+		}
+		prog, err := syntax.Compile(parsed.Simplify())
+		if err != nil {
+			continue
+		}
+		m.progs = append(m.progs, prog)
+	}
+	return m
+}
+
+// viable checks if any of the patterns could match input which starts
+// with the whole of the provided input and continues beyond it.
+func (m *prefixMatcher) viable(input []byte) bool {
+	for _, prog := range m.progs {
+		if prefixViable(prog, input) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixViable checks if a program has any thread waiting to match a
+// rune after it has matched the whole of the provided input from its
+// start. Since we don't know what will follow the input, any empty
+// width assertion at its end is assumed to hold, and an incomplete
+// UTF-8 sequence at its end is taken to be the start of a rune which
+// any waiting thread might match.
+func prefixViable(prog *syntax.Prog, input []byte) bool {
+	threads := prefixThreads(prog, []uint32{uint32(prog.Start)}, -1,
+		firstRune(input), len(input) == 0)
+
+	before := rune(-1)
+	for len(input) > 0 && len(threads) > 0 {
+		if !utf8.FullRune(input) {
+			return true
+		}
+		r, size := utf8.DecodeRune(input)
+		input = input[size:]
+
+		var next []uint32
+		for _, pc := range threads {
+			inst := &prog.Inst[pc]
+			if prefixMatchRune(inst, r) {
+				next = append(next, inst.Out)
+			}
+		}
+		before = r
+		threads = prefixThreads(prog, next, before, firstRune(input),
+			len(input) == 0)
+	}
+	return len(threads) > 0
+}
+
+// prefixThreads follows the provided program counters through any
+// instructions which don't consume a rune, and returns those of the
+// distinct instructions reached which do. The runes before and after
+// the current position are used to check empty width assertions,
+// unless the position is the end of the input, in which case they are
+// assumed to hold.
+func prefixThreads(prog *syntax.Prog, pcs []uint32, before, after rune,
+	atEnd bool) []uint32 {
+	seen := make(map[uint32]bool)
+	var threads []uint32
+	for len(pcs) > 0 {
+		pc := pcs[len(pcs)-1]
+		pcs = pcs[:len(pcs)-1]
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			pcs = append(pcs, inst.Out, inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			pcs = append(pcs, inst.Out)
+		case syntax.InstEmptyWidth:
+			if atEnd || inst.MatchEmptyWidth(before, after) {
+				pcs = append(pcs, inst.Out)
+			}
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny,
+			syntax.InstRuneAnyNotNL:
+			threads = append(threads, pc)
+		}
+	}
+	return threads
+}
+
+// prefixMatchRune checks if an instruction which consumes a rune
+// matches the provided rune.
+func prefixMatchRune(inst *syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRune1:
+		return r == inst.Rune[0]
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	}
+	return inst.MatchRune(r)
+}
+
+// firstRune returns the first rune of the input, or -1 if it is empty.
+func firstRune(input []byte) rune {
+	if len(input) == 0 {
+		return -1
+	}
+	r, _ := utf8.DecodeRune(input)
+	return r
+}
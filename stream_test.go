@@ -0,0 +1,212 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"reflect"
+	"testing"
+)
+
+func TestStreamLexer(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+", `"[^"]*"`,
+		"<", "<=", "<<="}, lexer.WithSkipPatterns("#[^\n]*"),
+		lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	type drain struct {
+		values     []string
+		incomplete bool
+	}
+	testCases := []struct {
+		feeds  []string
+		drains []drain
+		close  []string
+	}{
+		{
+			[]string{"abc 12 ", "de"},
+			[]drain{{[]string{"abc", "12"}, false}, {nil, true}},
+			[]string{"de", ""},
+		},
+		{
+			[]string{"ab", "c 1", "2\n"},
+			[]drain{{nil, true}, {[]string{"abc"}, true},
+				{[]string{"12"}, false}},
+			[]string{""},
+		},
+		{
+			[]string{`x "a multi`, "\nline\"", " y"},
+			[]drain{{[]string{"x"}, true},
+				{[]string{"\"a multi\nline\""}, false}, {nil, true}},
+			[]string{"y", ""},
+		},
+		{
+			[]string{"a <", "< b <", "= <<"},
+			[]drain{{[]string{"a"}, true}, {[]string{"<", "<", "b"}, true},
+				{[]string{"<="}, true}},
+			[]string{"<", "<", ""},
+		},
+		{
+			[]string{"a # com", "ment\n", "b"},
+			[]drain{{[]string{"a"}, true}, {nil, false}, {nil, true}},
+			[]string{"b", ""},
+		},
+		{
+			[]string{"", "  "},
+			[]drain{{nil, false}, {nil, false}},
+			[]string{""},
+		},
+	}
+
+	for n, tc := range testCases {
+		stream := l.Stream()
+		for i, feed := range tc.feeds {
+			stream.Feed([]byte(feed))
+			tokens, incomplete := stream.Drain()
+			var values []string
+			for _, token := range tokens {
+				values = append(values, token.Value)
+			}
+			want := tc.drains[i]
+			if !reflect.DeepEqual(values, want.values) ||
+				incomplete != want.incomplete {
+				t.Errorf("case %d, feed %d, got %q, %t, want %q, %t", n+1,
+					i+1, values, incomplete, want.values, want.incomplete)
+			}
+		}
+
+		tokens, err := stream.Close()
+		if err != nil {
+			t.Errorf("case %d, couldn't close stream: %v", n+1, err)
+		}
+		var values []string
+		for _, token := range tokens {
+			values = append(values, token.Value)
+		}
+		if !reflect.DeepEqual(values, tc.close) {
+			t.Errorf("case %d, got %q on close, want %q", n+1, values,
+				tc.close)
+		}
+	}
+}
+
+func TestStreamLexerPositions(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	input := "abc 12\nde fg 3"
+	want, err := l.LexString(input)
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	// However the input is divided, the stream finds the same tokens.
+
+	for size := 1; size <= len(input); size++ {
+		stream := l.Stream()
+		var tokens lexer.TokenList
+		for start := 0; start < len(input); start += size {
+			end := start + size
+			if end > len(input) {
+				end = len(input)
+			}
+			stream.Feed([]byte(input[start:end]))
+			drained, _ := stream.Drain()
+			tokens = append(tokens, drained...)
+		}
+		rest, err := stream.Close()
+		if err != nil {
+			t.Fatalf("size %d, couldn't close stream: %v", size, err)
+		}
+		tokens = append(tokens, rest...)
+
+		if len(tokens) != len(want) {
+			t.Errorf("size %d, got %v, want %v", size, tokens, want)
+			continue
+		}
+		for i := range tokens {
+			if tokens[i] != want[i] {
+				t.Errorf("size %d, got %+v, want %+v", size, tokens[i],
+					want[i])
+			}
+		}
+	}
+}
+
+func TestStreamLexerError(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	stream := l.Stream()
+	stream.Feed([]byte("ab cd ?"))
+	tokens, incomplete := stream.Drain()
+	if len(tokens) != 2 || incomplete {
+		t.Errorf("got %v, %t, want 2 tokens, false", tokens, incomplete)
+	}
+	if merr, ok := stream.Err().(lexer.MatchError); !ok || merr.Index != 6 {
+		t.Errorf("got error %v, want MatchError at 6", stream.Err())
+	}
+
+	stream.Feed([]byte("ef"))
+	if tokens, _ := stream.Drain(); len(tokens) != 0 {
+		t.Errorf("got tokens %v after error, want none", tokens)
+	}
+	if _, err := stream.Close(); err != stream.Err() {
+		t.Errorf("got error %v on close, want %v", err, stream.Err())
+	}
+}
+
+func TestStreamLexerOneByte(t *testing.T) {
+	testCases := []struct {
+		options []lexer.Option
+		input   string
+	}{
+		{nil, "aé éb aéb"},
+		{[]lexer.Option{lexer.WithNestedComment("/*", "*/")},
+			"a/*x /* y */*/b / c /*/**/*/"},
+		{[]lexer.Option{lexer.WithNestedComment("/*", "*/"),
+			lexer.WithTrivia()}, "a /*x*/  b/**/"},
+		{[]lexer.Option{lexer.WithTrivia()}, "a  b \t\n c  "},
+		{[]lexer.Option{lexer.WithErrorToken()}, "a /*  b ?? c *"},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{`[[:alpha:]é]+`, "/"}, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+		want, err := l.LexString(tc.input)
+		if err != nil {
+			t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+		}
+
+		stream := l.Stream()
+		var tokens lexer.TokenList
+		for i := 0; i < len(tc.input); i++ {
+			stream.Feed([]byte{tc.input[i]})
+			drained, _ := stream.Drain()
+			tokens = append(tokens, drained...)
+		}
+		rest, err := stream.Close()
+		if err != nil {
+			t.Errorf("case %d, couldn't close stream: %v", n+1, err)
+			continue
+		}
+		tokens = append(tokens, rest...)
+
+		if diff := tokens.Diff(want); diff != "" {
+			t.Errorf("case %d, got unexpected tokens:\n%s", n+1, diff)
+			continue
+		}
+		for i := range tokens {
+			if tokens[i] != want[i] {
+				t.Errorf("case %d, got %+v, want %+v", n+1, tokens[i],
+					want[i])
+			}
+		}
+	}
+}
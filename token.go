@@ -1,33 +1,154 @@
 package lexer
 
-// Token is a lexical token output by the lexical analyzer.
+import "fmt"
+
+// EOF is the ID of the token which marks the end of the input, when
+// the lexer was created with the WithEOFToken option.
+const EOF = -1
+
+// Whitespace is the ID of tokens containing whitespace skipped
+// between other tokens, when the lexer was created with the
+// WithTrivia option.
+const Whitespace = -2
+
+// Unmatched is the ID of tokens containing input which could not be
+// matched against any of the lexeme patterns, when the lexer was
+// created with the WithErrorToken option.
+const Unmatched = -3
+
+// Indent is the ID of tokens marking an increase in the indentation of
+// the lines of the input, when the lexer was created with the
+// WithIndentation option.
+const Indent = -4
+
+// Dedent is the ID of tokens marking a return to an earlier level of
+// indentation of the lines of the input, when the lexer was created
+// with the WithIndentation option.
+const Dedent = -5
+
+// Comment is the ID of tokens containing nested comments skipped
+// between other tokens, when the lexer was created with both the
+// WithNestedComment and WithTrivia options.
+const Comment = -6
+
+// Token is a lexical token output by the lexical analyzer. Tokens
+// are encoded to JSON as objects with lowercase field names, such as
+// {"id":0,"value":"how","index":0}, with any fields which are not set
+// omitted, other than the ID, value and index.
 type Token struct {
 	// ID is index of the string slice of lexeme patterns used to
 	// create the lexer at which the lexeme pattern used to identify
 	// this token is located.
-	ID int
+	ID int `json:"id"`
+	// Kind is the kind of the lexeme pattern used to identify this
+	// token, if the lexer was created with NewKinds.
+	Kind Kind `json:"kind,omitempty"`
+	// Name is the name associated with the lexeme pattern used to
+	// identify this token, if the lexer was created with names.
+	Name string `json:"name,omitempty"`
 	// Value is the actual string value of the lexeme found by the
 	// lexical analyzer.
-	Value string
+	Value string `json:"value"`
 	// Index is the position of the input at which the lexeme was
 	// found.
-	Index int
+	Index int `json:"index"`
+	// Line is the line of the input, starting at 1, on which the
+	// lexeme was found.
+	Line int `json:"line,omitempty"`
+	// Column is the position of the lexeme within its line, in
+	// runes, starting at 1.
+	Column int `json:"column,omitempty"`
+	// End is the position of the input immediately following the
+	// last byte of the lexeme.
+	End int `json:"end,omitempty"`
+	// Mode is the name of the mode in which the lexeme was found,
+	// if the lexer was created with NewModal.
+	Mode string `json:"mode,omitempty"`
+	// Sub is the keyword which the lexeme is, if the lexer was
+	// created with NewKeywords and the lexeme is one of its keywords.
+	Sub string `json:"sub,omitempty"`
+	// Raw is the text of the lexeme, if the Value was decoded from it
+	// by a decoder provided with the WithDecoder option.
+	Raw string `json:"raw,omitempty"`
+	// groups is the text matched by each capturing group of the lexeme
+	// pattern, as recorded with the WithGroups option. It is held by
+	// pointer so that tokens remain comparable.
+	groups *[]string
 }
 
-// Equals tests if two tokens are equal.
+// Equals tests if two tokens are equal. Name, Line, Column, End,
+// Mode, Sub and Kind are not compared, since they are derived from ID,
+// Index and Value.
 func (t Token) Equals(other Token) bool {
 	return t.ID == other.ID &&
 		t.Value == other.Value &&
 		t.Index == other.Index
 }
 
-// Less tests if a token is less than another token.
+// EqualsIgnoreIndex tests if two tokens are equal in the same way
+// as Equals, except that Index is not compared either, so that tokens
+// found at different positions in the input may be equal.
+func (t Token) EqualsIgnoreIndex(other Token) bool {
+	return t.ID == other.ID && t.Value == other.Value
+}
+
+// IsNewline tests if the token was matched by a lexeme pattern and
+// its value is a single newline character, as it is for tokens matched
+// by a lexeme pattern which is the newline character by itself,
+// including those created from a carriage return if the lexer was
+// created with the WithNormalizeNewlines option. Tokens with reserved
+// IDs, such as the Whitespace tokens returned with the WithTrivia
+// option, are never newlines, even if their value is "\n".
+func (t Token) IsNewline() bool {
+	return t.ID >= 0 && t.Value == "\n"
+}
+
+// Start returns the position of the input at which the lexeme was
+// found, which is the same as Index, so that with End it gives the
+// range of the input containing the lexeme, as in
+// input[token.Start():token.End], such as for a lexer created with
+// the WithoutValues option.
+func (t Token) Start() int {
+	return t.Index
+}
+
+// text returns the text of the lexeme, which is the Raw field if the
+// value was decoded, and otherwise the value.
+func (t Token) text() string {
+	if t.Raw != "" {
+		return t.Raw
+	}
+	return t.Value
+}
+
+// Len returns the length of the lexeme in bytes, or in runes if
+// the lexer was created with the WithRuneIndex option.
+func (t Token) Len() int {
+	return t.End - t.Index
+}
+
+// Less tests if a token is less than another token, comparing their
+// values, then their IDs if their values are equal, and then their
+// indices if their IDs are also equal.
 func (t Token) Less(other Token) bool {
-	if t.Value < other.Value {
-		return true
+	if t.Value != other.Value {
+		return t.Value < other.Value
 	}
-	if t.ID < other.ID {
-		return true
+	if t.ID != other.ID {
+		return t.ID < other.ID
 	}
 	return t.Index < other.Index
 }
+
+// String returns a string representation of a token, in the form
+// Token(id=0, value="how", index=0), with the value quoted so that
+// any control characters are visible. If the token has a name, it
+// is shown in place of the id, as in Token(name=Word, ...).
+func (t Token) String() string {
+	if t.Name != "" {
+		return fmt.Sprintf("Token(name=%s, value=%q, index=%d)",
+			t.Name, t.Value, t.Index)
+	}
+	return fmt.Sprintf("Token(id=%d, value=%q, index=%d)",
+		t.ID, t.Value, t.Index)
+}
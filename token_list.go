@@ -1,5 +1,12 @@
 package lexer
 
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
 // TokenList is a list of lexical tokens.
 type TokenList []Token
 
@@ -19,22 +26,369 @@ func (t TokenList) Equals(other TokenList) bool {
 		return false
 	}
 	for n := range t {
-		if t[n] != other[n] {
+		if !t[n].Equals(other[n]) {
 			return false
 		}
 	}
 	return true
 }
 
-// Less returns true if list[i] < list[j].
-func (t TokenList) Less(i, j int) bool {
-	if t[i].Value < t[j].Value {
-		return true
+// Diff returns a description of the first difference between the list
+// and another list, which is taken to be the expected one, such as for
+// the message of a failing test, or the empty string if the lists are
+// equal in the same way as for Equals. Tokens are numbered from 1, as
+// in "token 2: got Token(id=0, value="a", index=2), want
+// Token(id=1, value="a", index=2)".
+func (t TokenList) Diff(other TokenList) string {
+	for n := 0; n < len(t) || n < len(other); n++ {
+		switch {
+		case n >= len(other):
+			return fmt.Sprintf("token %d: got %v, want no more tokens",
+				n+1, t[n])
+		case n >= len(t):
+			return fmt.Sprintf("token %d: got no more tokens, want %v",
+				n+1, other[n])
+		case !t[n].Equals(other[n]):
+			return fmt.Sprintf("token %d: got %v, want %v",
+				n+1, t[n], other[n])
+		}
 	}
-	return t[i].ID < t[j].ID
+	return ""
+}
+
+// EqualsIgnoreIndex tests if two token lists are equal in the same
+// way as Equals, except that tokens are compared with
+// Token.EqualsIgnoreIndex, so that the positions of the tokens do not
+// need to match.
+func (t TokenList) EqualsIgnoreIndex(other TokenList) bool {
+	if len(t) != len(other) {
+		return false
+	}
+	for n := range t {
+		if !t[n].EqualsIgnoreIndex(other[n]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Less returns true if list[i] < list[j], as determined by
+// Token.Less.
+func (t TokenList) Less(i, j int) bool {
+	return t[i].Less(t[j])
 }
 
 // Swap swaps tokens i and j in the list.
 func (t TokenList) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
+
+// String returns a string representation of the list, with each
+// token on its own line preceded by its position in the list, in
+// the same format as written by Dump.
+func (t TokenList) String() string {
+	var b strings.Builder
+	t.Dump(&b)
+	return b.String()
+}
+
+// Dump writes each token in the list to w on its own line, preceded
+// by its position in the list, as in:
+//
+//	0: Token(id=0, value="how", index=0)
+//	1: Token(id=1, value="2", index=4)
+func (t TokenList) Dump(w io.Writer) error {
+	for n, token := range t {
+		if _, err := fmt.Fprintf(w, "%d: %s\n", n, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filter returns a new list containing, in their original order,
+// the tokens in the list for which pred returns true. The list
+// itself is not modified.
+func (t TokenList) Filter(pred func(Token) bool) TokenList {
+	filtered := TokenList{}
+	for _, token := range t {
+		if pred(token) {
+			filtered = append(filtered, token)
+		}
+	}
+	return filtered
+}
+
+// FilterByID returns a new list containing, in their original order,
+// the tokens in the list which have any of the provided IDs. The list
+// itself is not modified.
+func (t TokenList) FilterByID(ids ...int) TokenList {
+	return t.Filter(func(token Token) bool {
+		for _, id := range ids {
+			if token.ID == id {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Find returns the first token in the list for which pred returns
+// true, along with its position in the list, and true, or false if
+// there is no such token.
+func (t TokenList) Find(pred func(Token) bool) (Token, int, bool) {
+	return t.FindFrom(0, pred)
+}
+
+// FindFrom returns the first token in the list for which pred returns
+// true in the same way as Find, but starting the search from the
+// provided position in the list, rather than from its start, such as
+// to find the first "(" after another token. The position returned is
+// still that in the whole list.
+func (t TokenList) FindFrom(start int, pred func(Token) bool) (Token,
+	int, bool) {
+	if start < 0 {
+		start = 0
+	}
+	for n := start; n < len(t); n++ {
+		if pred(t[n]) {
+			return t[n], n, true
+		}
+	}
+	return Token{}, -1, false
+}
+
+// Map returns a new list containing the result of calling fn on each
+// token in the list, in order. The list itself is not modified.
+func (t TokenList) Map(fn func(Token) Token) TokenList {
+	mapped := make(TokenList, len(t))
+	for n, token := range t {
+		mapped[n] = fn(token)
+	}
+	return mapped
+}
+
+// TrimValue returns a new list in which all leading and trailing
+// runes contained in cutset have been removed from the value of each
+// token, such as to strip the quotes surrounding a string literal.
+// The positions of the tokens, including Index and End, are not
+// changed, so continue to refer to the untrimmed lexemes. The list
+// itself is not modified.
+func (t TokenList) TrimValue(cutset string) TokenList {
+	return t.Map(func(token Token) Token {
+		token.Value = strings.Trim(token.Value, cutset)
+		return token
+	})
+}
+
+// CountByID returns the number of tokens in the list with each ID.
+// IDs which do not appear in the list do not appear in the map.
+func (t TokenList) CountByID() map[int]int {
+	counts := make(map[int]int)
+	for _, token := range t {
+		counts[token.ID]++
+	}
+	return counts
+}
+
+// IDCount is the number of tokens or matches with an ID.
+type IDCount struct {
+	ID    int
+	Count int
+}
+
+// CountByIDSorted returns the number of tokens in the list with each
+// ID in the same way as CountByID, but as a slice sorted by ID, so
+// that the order is the same every time, such as for golden tests.
+func (t TokenList) CountByIDSorted() []IDCount {
+	return sortedCounts(t.CountByID())
+}
+
+// sortedCounts returns the counts in a map from IDs to counts as a
+// slice sorted by ID.
+func sortedCounts(counts map[int]int) []IDCount {
+	sorted := make([]IDCount, 0, len(counts))
+	for id, count := range counts {
+		sorted = append(sorted, IDCount{id, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// Count returns the number of tokens in the list with the provided ID.
+func (t TokenList) Count(id int) int {
+	count := 0
+	for _, token := range t {
+		if token.ID == id {
+			count++
+		}
+	}
+	return count
+}
+
+// Lines returns the number of newline tokens in the list, as
+// determined by Token.IsNewline.
+func (t TokenList) Lines() int {
+	count := 0
+	for _, token := range t {
+		if token.IsNewline() {
+			count++
+		}
+	}
+	return count
+}
+
+// Join returns the values of the tokens in the list concatenated,
+// with sep between each value and the next.
+func (t TokenList) Join(sep string) string {
+	values := make([]string, len(t))
+	for n, token := range t {
+		values[n] = token.Value
+	}
+	return strings.Join(values, sep)
+}
+
+// Coalesce returns a new list in which each run of adjacent tokens
+// with the same ID, if it is one of the provided IDs, is merged into a
+// single token. The merged token is the first token of the run, with
+// the values of all the tokens of the run concatenated, and the End of
+// the last. Tokens with any other ID are unchanged.
+func (t TokenList) Coalesce(ids ...int) TokenList {
+	merge := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		merge[id] = true
+	}
+
+	list := TokenList{}
+	for _, token := range t {
+		last := len(list) - 1
+		if last >= 0 && merge[token.ID] && list[last].ID == token.ID {
+			list[last].Value += token.Value
+			list[last].End = token.End
+			continue
+		}
+		list = append(list, token)
+	}
+	return list
+}
+
+// Reconstruct returns an approximation of the input from which the
+// list was lexed, with the value of each token, or its Raw text if the
+// value was decoded, placed at its Index by filling any gap since the
+// end of the previous token with spaces.
+// Unless the list includes trivia tokens, the whitespace of the input
+// is lost, so the input is reproduced exactly only if every gap
+// between its tokens is spaces. Tokens which overlap the previous
+// token are placed immediately after it.
+func (t TokenList) Reconstruct() string {
+	var b strings.Builder
+	pos := 0
+	for _, token := range t {
+		if gap := token.Index - pos; gap > 0 {
+			b.WriteString(strings.Repeat(" ", gap))
+			pos = token.Index
+		}
+		b.WriteString(token.text())
+
+		// Tokens which were not returned by a lexer may not have
+		// an End, in which case assume that Index is in bytes.
+
+		if token.End > token.Index {
+			pos += token.End - token.Index
+		} else {
+			pos += len(token.text())
+		}
+	}
+	return b.String()
+}
+
+// Between returns the part of the list containing the tokens whose
+// Index is at least start and less than end. The list must be in
+// order of position, as returned by the lexer, and the returned list
+// shares its storage.
+func (t TokenList) Between(start, end int) TokenList {
+	first := sort.Search(len(t), func(i int) bool {
+		return t[i].Index >= start
+	})
+	last := sort.Search(len(t), func(i int) bool {
+		return t[i].Index >= end
+	})
+	if last < first {
+		last = first
+	}
+	return t[first:last]
+}
+
+// At returns the token whose lexeme contains the provided position in
+// the input, and true, or false if no token does, such as when the
+// position is in whitespace between tokens. The list must be in order
+// of position, as returned by the lexer. Tokens which were not
+// returned by a lexer may not have an End, in which case it is taken
+// to be Index plus the length of the value in bytes.
+func (t TokenList) At(offset int) (Token, bool) {
+	n := sort.Search(len(t), func(i int) bool {
+		return t[i].Index > offset
+	}) - 1
+	if n < 0 {
+		return Token{}, false
+	}
+
+	end := t[n].End
+	if end <= t[n].Index {
+		end = t[n].Index + len(t[n].Value)
+	}
+	if offset >= end {
+		return Token{}, false
+	}
+	return t[n], true
+}
+
+// Span returns the position in the input of the start of the first
+// token in the list, and of the end of the last, so that the list
+// covers the input from start up to but not including end. The list
+// must be in order of position, as returned by the lexer. Tokens which
+// were not returned by a lexer may not have an End, in which case it
+// is taken to be Index plus the length of the value in bytes. For an
+// empty list, both start and end are 0.
+func (t TokenList) Span() (start, end int) {
+	if len(t) == 0 {
+		return 0, 0
+	}
+
+	last := t[len(t)-1]
+	end = last.End
+	if end <= last.Index {
+		end = last.Index + len(last.Value)
+	}
+	return t[0].Index, end
+}
+
+// ByLine returns the tokens in the list grouped by the line of the
+// input on which each starts, in order, so that the list at index n of
+// the result holds the tokens on line n+1. The input must be that from
+// which the list was lexed, and the Index of each token must be in
+// bytes, as for LineAt. There is one list for each line of the input,
+// including an empty last line after a final newline character, and
+// lines without tokens have empty lists.
+func (t TokenList) ByLine(input []byte) []TokenList {
+	starts := []int{0}
+	for i, c := range input {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+
+	lines := make([]TokenList, len(starts))
+	for _, token := range t {
+		line := sort.Search(len(starts), func(i int) bool {
+			return starts[i] > token.Index
+		}) - 1
+		if line < 0 {
+			line = 0
+		}
+		lines[line] = append(lines[line], token)
+	}
+	return lines
+}
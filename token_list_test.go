@@ -0,0 +1,739 @@
+package lexer_test
+
+import (
+	"encoding/json"
+	"github.com/paulgriffiths/lexer"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTokenListString(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "how", Index: 0},
+		lexer.Token{ID: 1, Name: "Number", Value: "2", Index: 4},
+		lexer.Token{ID: 2, Value: "\n", Index: 5},
+	}
+
+	want := `0: Token(id=0, value="how", index=0)
+1: Token(name=Number, value="2", index=4)
+2: Token(id=2, value="\n", index=5)
+`
+	if got := list.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got := (lexer.TokenList{}).String(); got != "" {
+		t.Errorf("got %q for empty list, want empty string", got)
+	}
+}
+
+func TestTokenListFilter(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "20", Index: 0},
+		lexer.Token{ID: 0, Value: "cats", Index: 3},
+		lexer.Token{ID: 2, Value: ",", Index: 7},
+		lexer.Token{ID: 0, Value: "catch", Index: 9},
+		lexer.Token{ID: 1, Value: "100", Index: 15},
+		lexer.Token{ID: 2, Value: ".", Index: 19},
+	}
+	original := append(lexer.TokenList{}, list...)
+
+	testCases := []struct {
+		filtered lexer.TokenList
+		want     lexer.TokenList
+	}{
+		{
+			list.Filter(func(token lexer.Token) bool {
+				return len(token.Value) > 3
+			}),
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "cats", Index: 3},
+				lexer.Token{ID: 0, Value: "catch", Index: 9},
+			},
+		},
+		{
+			list.FilterByID(0, 1),
+			lexer.TokenList{
+				lexer.Token{ID: 1, Value: "20", Index: 0},
+				lexer.Token{ID: 0, Value: "cats", Index: 3},
+				lexer.Token{ID: 0, Value: "catch", Index: 9},
+				lexer.Token{ID: 1, Value: "100", Index: 15},
+			},
+		},
+		{
+			list.FilterByID(3),
+			lexer.TokenList{},
+		},
+	}
+
+	for n, tc := range testCases {
+		if !tc.filtered.Equals(tc.want) {
+			t.Errorf("case %d, got %v, want %v", n+1, tc.filtered, tc.want)
+		}
+	}
+
+	if !list.Equals(original) {
+		t.Errorf("list modified, got %v, want %v", list, original)
+	}
+}
+
+func TestTokenListMap(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "Foo", Index: 0},
+		lexer.Token{ID: 1, Value: "`terminal`", Index: 4},
+		lexer.Token{ID: 1, Value: "\"quoted\"", Index: 15},
+	}
+	original := append(lexer.TokenList{}, list...)
+
+	testCases := []struct {
+		mapped lexer.TokenList
+		want   lexer.TokenList
+	}{
+		{
+			list.Map(func(token lexer.Token) lexer.Token {
+				token.Value = strings.ToLower(token.Value)
+				return token
+			}),
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "foo", Index: 0},
+				lexer.Token{ID: 1, Value: "`terminal`", Index: 4},
+				lexer.Token{ID: 1, Value: "\"quoted\"", Index: 15},
+			},
+		},
+		{
+			list.TrimValue("`\""),
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "Foo", Index: 0},
+				lexer.Token{ID: 1, Value: "terminal", Index: 4},
+				lexer.Token{ID: 1, Value: "quoted", Index: 15},
+			},
+		},
+	}
+
+	for n, tc := range testCases {
+		if !tc.mapped.Equals(tc.want) {
+			t.Errorf("case %d, got %v, want %v", n+1, tc.mapped, tc.want)
+		}
+	}
+
+	if !list.Equals(original) {
+		t.Errorf("list modified, got %v, want %v", list, original)
+	}
+}
+
+func TestTokenListCount(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "20", Index: 0},
+		lexer.Token{ID: 0, Value: "cats", Index: 3},
+		lexer.Token{ID: 2, Value: ",", Index: 7},
+		lexer.Token{ID: 0, Value: "catch", Index: 9},
+		lexer.Token{ID: 1, Value: "100", Index: 15},
+		lexer.Token{ID: 0, Value: "rats", Index: 19},
+	}
+
+	want := map[int]int{0: 3, 1: 2, 2: 1}
+	counts := list.CountByID()
+	if len(counts) != len(want) {
+		t.Errorf("got %d ids, want %d", len(counts), len(want))
+	}
+
+	for id, count := range want {
+		if counts[id] != count {
+			t.Errorf("id %d, got count %d, want %d", id, counts[id], count)
+		}
+		if got := list.Count(id); got != count {
+			t.Errorf("id %d, got count %d, want %d", id, got, count)
+		}
+	}
+
+	if got := list.Count(3); got != 0 {
+		t.Errorf("id 3, got count %d, want 0", got)
+	}
+
+	wantSorted := []lexer.IDCount{{ID: 0, Count: 3}, {ID: 1, Count: 2},
+		{ID: 2, Count: 1}}
+	if got := list.CountByIDSorted(); !reflect.DeepEqual(got, wantSorted) {
+		t.Errorf("got sorted counts %v, want %v", got, wantSorted)
+	}
+	if got := (lexer.TokenList{}).CountByIDSorted(); len(got) != 0 {
+		t.Errorf("got sorted counts %v for empty list, want none", got)
+	}
+}
+
+func TestTokenListEqualsIgnoreIndex(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("  abc   123\n\tdef  ")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "abc"},
+		lexer.Token{ID: 1, Value: "123"},
+		lexer.Token{ID: 0, Value: "def"},
+	}
+	if !tokens.EqualsIgnoreIndex(want) {
+		t.Errorf("got %v, want %v", tokens, want)
+	}
+	if tokens.Equals(want) {
+		t.Errorf("got equal lists from Equals, want unequal")
+	}
+	if tokens.EqualsIgnoreIndex(want[:2]) {
+		t.Errorf("got equal lists of different lengths")
+	}
+}
+
+func TestTokenListJoin(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a", Index: 0},
+		lexer.Token{ID: 1, Value: "=", Index: 2},
+		lexer.Token{ID: 0, Value: "b", Index: 4},
+	}
+
+	testCases := []struct {
+		sep  string
+		want string
+	}{
+		{"", "a=b"},
+		{" ", "a = b"},
+		{", ", "a, =, b"},
+	}
+
+	for n, tc := range testCases {
+		if got := list.Join(tc.sep); got != tc.want {
+			t.Errorf("case %d, got %q, want %q", n+1, got, tc.want)
+		}
+	}
+
+	if got := (lexer.TokenList{}).Join(" "); got != "" {
+		t.Errorf("got %q for empty list, want empty string", got)
+	}
+}
+
+func TestTokenListReconstruct(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    string
+		options []lexer.Option
+	}{
+		{"a = b  *  c", "a = b  *  c", nil},
+		{"  a=b ", "  a=b", nil},
+		{"a\tb\n\nc", "a b  c", nil},
+		{"é = ü", "é = ü", nil},
+		{"é = ü", "é = ü", []lexer.Option{lexer.WithRuneIndex()}},
+		{"a\tb\n\nc ", "a\tb\n\nc ", []lexer.Option{lexer.WithTrivia()}},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New([]string{"[[:alpha:]éü]", "=", `\*`},
+			tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Errorf("case %d, couldn't get tokens: %v", n+1, err)
+			continue
+		}
+
+		if got := tokens.Reconstruct(); got != tc.want {
+			t.Errorf("case %d, got %q, want %q", n+1, got, tc.want)
+		}
+	}
+}
+
+func TestTokenListSort(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "b", Index: 9},
+		lexer.Token{ID: 0, Value: "c", Index: 0},
+		lexer.Token{ID: 1, Value: "a", Index: 5},
+		lexer.Token{ID: 0, Value: "b", Index: 7},
+		lexer.Token{ID: 1, Value: "b", Index: 3},
+		lexer.Token{ID: 2, Value: "a", Index: 1},
+		lexer.Token{ID: 0, Value: "b", Index: 2},
+	}
+
+	want := lexer.TokenList{
+		lexer.Token{ID: 1, Value: "a", Index: 5},
+		lexer.Token{ID: 2, Value: "a", Index: 1},
+		lexer.Token{ID: 0, Value: "b", Index: 2},
+		lexer.Token{ID: 0, Value: "b", Index: 7},
+		lexer.Token{ID: 1, Value: "b", Index: 3},
+		lexer.Token{ID: 1, Value: "b", Index: 9},
+		lexer.Token{ID: 0, Value: "c", Index: 0},
+	}
+
+	// Exactly one of a < b, b < a, or a equal to b must hold for
+	// every pair of tokens, or sorting may give inconsistent results.
+
+	for i, a := range list {
+		for j, b := range list {
+			n := 0
+			if a.Less(b) {
+				n++
+			}
+			if b.Less(a) {
+				n++
+			}
+			if a.Equals(b) {
+				n++
+			}
+			if n != 1 {
+				t.Errorf("tokens %d and %d, got %d relations, want 1",
+					i+1, j+1, n)
+			}
+		}
+	}
+
+	sort.Sort(list)
+	if !list.Equals(want) {
+		t.Errorf("got %v, want %v", list, want)
+	}
+	if !sort.IsSorted(list) {
+		t.Errorf("list is not sorted")
+	}
+}
+
+func TestTokenListJSON(t *testing.T) {
+	l, err := lexer.NewNamed([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		[]string{"", "Number"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("how\n2")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	data, jerr := json.Marshal(tokens)
+	if jerr != nil {
+		t.Fatalf("couldn't marshal tokens: %v", jerr)
+	}
+
+	want := `[{"id":0,"value":"how","index":0,"line":1,"column":1,"end":3},` +
+		`{"id":1,"name":"Number","value":"2","index":4,"line":2,` +
+		`"column":1,"end":5}]`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+
+	var decoded lexer.TokenList
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("couldn't unmarshal tokens: %v", err)
+	}
+	if len(decoded) != len(tokens) {
+		t.Fatalf("got %d tokens, want %d", len(decoded), len(tokens))
+	}
+	for n := range tokens {
+		if decoded[n] != tokens[n] {
+			t.Errorf("case %d, got %+v, want %+v", n+1, decoded[n], tokens[n])
+		}
+	}
+
+	data, jerr = json.Marshal(lexer.Token{ID: 0, Value: "how", Index: 0})
+	if jerr != nil {
+		t.Fatalf("couldn't marshal token: %v", jerr)
+	}
+	if want := `{"id":0,"value":"how","index":0}`; string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestTokenListFind(t *testing.T) {
+	list := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "f", Index: 0},
+		lexer.Token{ID: 1, Value: "(", Index: 1},
+		lexer.Token{ID: 0, Value: "x", Index: 2},
+		lexer.Token{ID: 1, Value: "(", Index: 4},
+	}
+	isParen := func(token lexer.Token) bool { return token.Value == "(" }
+
+	testCases := []struct {
+		start int
+		index int
+		found bool
+	}{
+		{-1, 1, true},
+		{0, 1, true},
+		{1, 1, true},
+		{2, 3, true},
+		{4, -1, false},
+		{9, -1, false},
+	}
+
+	for n, tc := range testCases {
+		token, index, found := list.FindFrom(tc.start, isParen)
+		if index != tc.index || found != tc.found {
+			t.Errorf("case %d, got %d, %t, want %d, %t", n+1, index, found,
+				tc.index, tc.found)
+		} else if found && !token.Equals(list[index]) {
+			t.Errorf("case %d, got token %v, want %v", n+1, token,
+				list[index])
+		}
+	}
+
+	if token, index, found := list.Find(isParen); !found || index != 1 ||
+		token.Index != 1 {
+		t.Errorf("got %v at %d, found %t, want ( at 1", token, index, found)
+	}
+	if _, _, found := list.Find(func(lexer.Token) bool {
+		return false
+	}); found {
+		t.Errorf("found token matching nothing")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		list.FindFrom(2, isParen)
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations, want none", allocs)
+	}
+}
+
+func TestTokenListBinary(t *testing.T) {
+	l, err := lexer.NewKeywords("[[:alpha:]]+", []string{"if"},
+		lexer.WithFilename("input.txt"), lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("if x\ny if")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+	tokens = append(tokens, lexer.Token{ID: 3, Kind: 7, Name: "Back",
+		Value: "é", Index: 2, Mode: "m", Raw: `"\u00e9"`})
+
+	data, merr := tokens.MarshalBinary()
+	if merr != nil {
+		t.Fatalf("couldn't marshal tokens: %v", merr)
+	}
+	if encoded, _ := json.Marshal(tokens); len(data) >= len(encoded)/2 {
+		t.Errorf("got %d bytes, want fewer than half the %d of JSON",
+			len(data), len(encoded))
+	}
+
+	var decoded lexer.TokenList
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("couldn't unmarshal tokens: %v", err)
+	}
+	if len(decoded) != len(tokens) {
+		t.Fatalf("got %d tokens, want %d", len(decoded), len(tokens))
+	}
+	for n := range tokens {
+		if decoded[n] != tokens[n] {
+			t.Errorf("case %d, got %+v, want %+v", n+1, decoded[n], tokens[n])
+		}
+	}
+
+	// Truncated or altered data is rejected, leaving the list as it was.
+
+	for _, bad := range [][]byte{nil, {2}, data[:len(data)-1],
+		append(data[:len(data):len(data)], 0)} {
+		if err := decoded.UnmarshalBinary(bad); err == nil {
+			t.Errorf("got no error for data %v, want BinaryDataError", bad)
+		} else if _, ok := err.(lexer.BinaryDataError); !ok {
+			t.Errorf("got error %v, want BinaryDataError", err)
+		}
+	}
+	if len(decoded) != len(tokens) {
+		t.Errorf("got %d tokens after failures, want %d", len(decoded),
+			len(tokens))
+	}
+}
+
+func TestTokenListBetweenAndAt(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+"},
+		lexer.WithEOFToken())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("abc  12 de")
+	if err != nil {
+		t.Fatalf("couldn't get tokens: %v", err)
+	}
+
+	betweenCases := []struct {
+		start, end int
+		want       string
+	}{
+		{0, 10, "abc 12 de"},
+		{0, 11, "abc 12 de "},
+		{1, 8, "12"},
+		{1, 9, "12 de"},
+		{5, 7, "12"},
+		{3, 5, ""},
+		{8, 2, ""},
+	}
+
+	for n, tc := range betweenCases {
+		if got := tokens.Between(tc.start, tc.end).Join(" "); got != tc.want {
+			t.Errorf("case %d, got %q, want %q", n+1, got, tc.want)
+		}
+	}
+
+	atCases := []struct {
+		offset int
+		want   string
+		found  bool
+	}{
+		{0, "abc", true},
+		{2, "abc", true},
+		{3, "", false},
+		{6, "12", true},
+		{7, "", false},
+		{9, "de", true},
+		{10, "", false},
+		{-1, "", false},
+	}
+
+	for n, tc := range atCases {
+		token, found := tokens.At(tc.offset)
+		if found != tc.found || token.Value != tc.want {
+			t.Errorf("case %d, got %q, %t, want %q, %t",
+				n+1, token.Value, found, tc.want, tc.found)
+		}
+	}
+}
+
+func TestTokenListByLine(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+", `/\*(?s:.)*?\*/`})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	testCases := []struct {
+		input string
+		lines []int
+	}{
+		{"", []int{0}},
+		{"a b", []int{2}},
+		{"a\n\n  \nb c", []int{1, 0, 0, 2}},
+		{"a\nb\n", []int{1, 1, 0}},
+		{"a /*\n*/ b\nc", []int{2, 1, 1}},
+	}
+
+	for n, tc := range testCases {
+		tokens, err := l.LexString(tc.input)
+		if err != nil {
+			t.Fatalf("case %d, couldn't lex input: %v", n+1, err)
+		}
+
+		lines := tokens.ByLine([]byte(tc.input))
+		if len(lines) != len(tc.lines) {
+			t.Errorf("case %d, got %d lines, want %d",
+				n+1, len(lines), len(tc.lines))
+			continue
+		}
+
+		var joined lexer.TokenList
+		for i, line := range lines {
+			if len(line) != tc.lines[i] {
+				t.Errorf("case %d, line %d, got %d tokens, want %d",
+					n+1, i+1, len(line), tc.lines[i])
+			}
+			for _, token := range line {
+				if token.Line != i+1 {
+					t.Errorf("case %d, got token on line %d in list %d",
+						n+1, token.Line, i+1)
+				}
+			}
+			joined = append(joined, line...)
+		}
+		if !joined.Equals(tokens) {
+			t.Errorf("case %d, got %v, want %v", n+1, joined, tokens)
+		}
+	}
+}
+
+func TestTokenListDiff(t *testing.T) {
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "a", Index: 0},
+		lexer.Token{ID: 1, Value: "+", Index: 2},
+	}
+
+	testCases := []struct {
+		got  lexer.TokenList
+		diff string
+	}{
+		{
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0, Line: 1},
+				lexer.Token{ID: 1, Value: "+", Index: 2, Line: 1},
+			},
+			"",
+		},
+		{
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+				lexer.Token{ID: 1, Value: "-", Index: 2},
+			},
+			`token 2: got Token(id=1, value="-", index=2), ` +
+				`want Token(id=1, value="+", index=2)`,
+		},
+		{
+			lexer.TokenList{
+				lexer.Token{ID: 0, Value: "a", Index: 0},
+			},
+			`token 2: got no more tokens, ` +
+				`want Token(id=1, value="+", index=2)`,
+		},
+		{
+			append(want[:2:2], lexer.Token{ID: 0, Value: "b", Index: 4}),
+			`token 3: got Token(id=0, value="b", index=4), ` +
+				`want no more tokens`,
+		},
+	}
+
+	for n, tc := range testCases {
+		if diff := tc.got.Diff(want); diff != tc.diff {
+			t.Errorf("case %d, got %q, want %q", n+1, diff, tc.diff)
+		}
+	}
+}
+
+func TestTokenListCoalesce(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]", "[[:digit:]]+", ","},
+		lexer.WithTrivia())
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("ab 1,2 cd")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	got := tokens.Coalesce(0, 1)
+	want := lexer.TokenList{
+		lexer.Token{ID: 0, Value: "ab", Index: 0},
+		lexer.Token{ID: lexer.Whitespace, Value: " ", Index: 2},
+		lexer.Token{ID: 1, Value: "1", Index: 3},
+		lexer.Token{ID: 2, Value: ",", Index: 4},
+		lexer.Token{ID: 1, Value: "2", Index: 5},
+		lexer.Token{ID: lexer.Whitespace, Value: " ", Index: 6},
+		lexer.Token{ID: 0, Value: "cd", Index: 7},
+	}
+	if diff := got.Diff(want); diff != "" {
+		t.Errorf("tokens not equals, %s", diff)
+	}
+	if got[0].End != 2 || got[6].End != 9 {
+		t.Errorf("got ends %d and %d, want 2 and 9", got[0].End, got[6].End)
+	}
+
+	// Without any of the IDs, the list is unchanged, and the original
+	// list is never modified.
+
+	if diff := tokens.Coalesce().Diff(tokens); diff != "" {
+		t.Errorf("tokens not equals, %s", diff)
+	}
+	if tokens[0].Value != "a" {
+		t.Errorf("original list modified, got %q, want %q",
+			tokens[0].Value, "a")
+	}
+}
+
+func TestTokenListSpan(t *testing.T) {
+	l, err := lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	tokens, err := l.LexString("  abc de  ")
+	if err != nil {
+		t.Fatalf("couldn't lex input: %v", err)
+	}
+
+	testCases := []struct {
+		list       lexer.TokenList
+		start, end int
+	}{
+		{tokens, 2, 8},
+		{tokens[:1], 2, 5},
+		{nil, 0, 0},
+		{lexer.TokenList{lexer.Token{Value: "xyz", Index: 3}}, 3, 6},
+	}
+
+	for n, tc := range testCases {
+		if start, end := tc.list.Span(); start != tc.start || end != tc.end {
+			t.Errorf("case %d, got span %d to %d, want %d to %d",
+				n+1, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+func TestTokenListSplice(t *testing.T) {
+	testCases := []struct {
+		input       string
+		start, end  int
+		replacement string
+	}{
+		{"ab cd\nef 12", 2, 2, "x"},
+		{"ab cd\nef 12", 2, 3, ""},
+		{"ab cd\nef 12", 3, 3, "\n"},
+		{"ab cd\nef 12\ngh", 5, 6, " "},
+		{`ab "x" cd "y" ef`, 3, 3, `"`},
+		{`ab "x" cd "y" ef`, 4, 5, "x\ny"},
+		{"ab cd 12", 8, 8, " 9"},
+		{"ab cd 12", 0, 0, "z "},
+		{"a <b c", 3, 3, "="},
+		{"a <= b c", 3, 4, ""},
+		{"", 0, 0, "ab"},
+		{"ab cd", 0, 5, ""},
+	}
+
+	for _, options := range [][]lexer.Option{nil, {lexer.WithEOFToken()}} {
+		l, err := lexer.New([]string{"[[:alpha:]]+", "[[:digit:]]+",
+			`"[^"]*"`, `"`, "<", "<=", "\n"}, options...)
+		if err != nil {
+			t.Fatalf("couldn't create lexer: %v", err)
+		}
+
+		for n, tc := range testCases {
+			list, err := l.LexString(tc.input)
+			if err != nil {
+				t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+			input := tc.input[:tc.start] + tc.replacement + tc.input[tc.end:]
+			want, err := l.LexString(input)
+			if err != nil {
+				t.Fatalf("case %d, couldn't get tokens: %v", n+1, err)
+			}
+
+			tokens, err := list.Splice(l, []byte(input), tc.start, tc.end,
+				len(tc.replacement))
+			if err != nil {
+				t.Fatalf("case %d, couldn't splice tokens: %v", n+1, err)
+			}
+			if len(tokens) != len(want) {
+				t.Errorf("case %d, got %v, want %v", n+1, tokens, want)
+				continue
+			}
+			for i := range tokens {
+				if tokens[i] != want[i] {
+					t.Errorf("case %d, got %+v, want %+v", n+1, tokens[i],
+						want[i])
+				}
+			}
+		}
+	}
+
+	l, err := lexer.New([]string{"[[:alpha:]]+"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+	_, err = lexer.TokenList{}.Splice(l, []byte("ab"), 1, 1, 2)
+	want := lexer.EditRangeError{Start: 1, End: 1, NewLength: 2, Length: 2}
+	if eerr, ok := err.(lexer.EditRangeError); !ok || eerr != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
@@ -0,0 +1,52 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+func TestTokenString(t *testing.T) {
+	testCases := []struct {
+		token lexer.Token
+		want  string
+	}{
+		{
+			lexer.Token{ID: 0, Value: "how", Index: 0},
+			`Token(id=0, value="how", index=0)`,
+		},
+		{
+			lexer.Token{ID: 4, Value: "\n", Index: 5},
+			`Token(id=4, value="\n", index=5)`,
+		},
+		{
+			lexer.Token{ID: 1, Name: "Number", Value: "42", Index: 7},
+			`Token(name=Number, value="42", index=7)`,
+		},
+	}
+
+	for n, tc := range testCases {
+		if got := tc.token.String(); got != tc.want {
+			t.Errorf("case %d, got %s, want %s", n+1, got, tc.want)
+		}
+	}
+}
+
+func TestTokenEqualsIgnoreIndex(t *testing.T) {
+	token := lexer.Token{ID: 1, Value: "42", Index: 7}
+
+	testCases := []struct {
+		other lexer.Token
+		want  bool
+	}{
+		{lexer.Token{ID: 1, Value: "42", Index: 7}, true},
+		{lexer.Token{ID: 1, Value: "42", Index: 0}, true},
+		{lexer.Token{ID: 2, Value: "42", Index: 7}, false},
+		{lexer.Token{ID: 1, Value: "43", Index: 7}, false},
+	}
+
+	for n, tc := range testCases {
+		if got := token.EqualsIgnoreIndex(tc.other); got != tc.want {
+			t.Errorf("case %d, got %t, want %t", n+1, got, tc.want)
+		}
+	}
+}
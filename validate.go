@@ -0,0 +1,141 @@
+package lexer
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+// Validate checks each of the provided lexeme patterns on its own,
+// without creating a lexer, and returns a slice of errors in the same
+// order as the patterns, in which the error for each pattern which
+// could be used to create a lexer is nil. The error for any other
+// pattern is a PatternError if it can't be compiled, or an
+// EmptyMatchPatternError if it matches the empty string, so that every
+// invalid pattern in a set provided by a user may be reported at once.
+func Validate(patterns []string) []error {
+	errs := make([]error, len(patterns))
+	for i, pattern := range patterns {
+		if err := checkPattern(i, pattern); err != nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// checkPattern checks that a lexeme pattern with the provided index
+// can be compiled, and doesn't match the empty string.
+func checkPattern(index int, pattern string) Error {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return newPatternError(index, pattern, err)
+	}
+	if compiled.MatchString("") {
+		return newEmptyMatchPatternError(index, pattern)
+	}
+	return nil
+}
+
+// Validate checks the lexeme patterns of the lexer for mistakes which
+// do not prevent the lexer from being created, but which are unlikely
+// to be intended, and returns an error describing the first one found,
+// or nil if none are found. Currently, it returns a ShadowedPatternError
+// if a pattern can never be matched because an earlier pattern always
+// wins instead. Only simple cases are detected, where the later pattern
+// is identical to the earlier one or is a literal string which the
+// earlier one matches, or, if the lexer was created with the
+// WithFirstMatch option, where the earlier pattern matches the start
+// of every match of the later one. An earlier pattern containing an
+// empty width assertion, such as \b or $, only shadows a later pattern
+// identical to it, since whether it matches depends on the input
+// around the lexeme. Many other shadowed patterns are therefore not
+// reported, but any pattern which is reported is certainly shadowed.
+// Patterns removed with Without are ignored. For a modal lexer, the
+// modes are checked in order of their names.
+func (l *Lexer) Validate() Error {
+	if l.modes != nil {
+		names := make([]string, 0, len(l.modes))
+		for mode := range l.modes {
+			names = append(names, mode)
+		}
+		sort.Strings(names)
+
+		for _, mode := range names {
+			if err := l.modes[mode].Validate(); err != nil {
+				serr := err.(ShadowedPatternError)
+				serr.Mode = mode
+				return serr
+			}
+		}
+		return nil
+	}
+
+	patterns := append(l.lexemes[:len(l.lexemes):len(l.lexemes)],
+		l.skipPatterns...)
+
+	// For us to conclude that a pattern would win against a later
+	// pattern, it must match the whole of the literal string which
+	// is the later pattern, or, when the first matching pattern wins
+	// rather than the longest, any part of the literal string with
+	// which every match of the later pattern starts.
+
+	flags := ""
+	if l.caseInsensitive {
+		flags = "(?i)"
+	}
+	whole := make([]*regexp.Regexp, len(patterns))
+	start := make([]*regexp.Regexp, len(patterns))
+	asserts := make([]bool, len(patterns))
+	for i, pattern := range patterns {
+		whole[i] = regexp.MustCompile(flags + "^(?:" + pattern + ")$")
+		start[i] = regexp.MustCompile(flags + "^(?:" + pattern + ")")
+		asserts[i] = hasEmptyWidth(pattern)
+	}
+
+	// Patterns removed with Without are all replaced by the same
+	// pattern, which never matches, so they can't shadow each other.
+
+	for j, pattern := range patterns {
+		if pattern == neverMatch {
+			continue
+		}
+		prefix, complete := regexp.MustCompile(pattern).LiteralPrefix()
+		for i := 0; i < j; i++ {
+			if patterns[i] == pattern || (!asserts[i] &&
+				((complete && whole[i].MatchString(prefix)) ||
+					(l.firstMatch && start[i].MatchString(prefix)))) {
+				return newShadowedPatternError(j, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasEmptyWidth checks if a pattern contains an empty width assertion,
+// such as \b or $, which makes whether it matches depend on the input
+// before or after the lexeme, as well as the lexeme itself. A pattern
+// which can't be parsed is taken to contain one.
+func hasEmptyWidth(pattern string) bool {
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return true
+	}
+	return containsEmptyWidth(parsed)
+}
+
+// containsEmptyWidth checks if a parsed expression or any of its
+// subexpressions is an empty width assertion.
+func containsEmptyWidth(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	}
+	for _, sub := range re.Sub {
+		if containsEmptyWidth(sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package lexer_test
+
+import (
+	"github.com/paulgriffiths/lexer"
+	"testing"
+)
+
+func TestValidateShadowed(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		options []lexer.Option
+		index   int
+		by      int
+	}{
+		{[]string{"[[:alpha:]]+", "if", "[[:digit:]]+"}, nil, 1, 0},
+		{[]string{"[[:digit:]]+", "==", "[[:digit:]]+"}, nil, 2, 0},
+		{[]string{"IF", "if", "[[:digit:]]+"},
+			[]lexer.Option{lexer.WithCaseInsensitive()}, 1, 0},
+		{[]string{"[[:digit:]]+", "[ab]"},
+			[]lexer.Option{lexer.WithSkipPatterns("a")}, 2, 1},
+		{[]string{"=", "==", "x+", "[[:digit:]]+"},
+			[]lexer.Option{lexer.WithFirstMatch()}, 1, 0},
+		{[]string{"==", "=", "[[:digit:]]", "1[[:digit:]]+"},
+			[]lexer.Option{lexer.WithFirstMatch()}, 3, 2},
+		{[]string{"[[:alpha:]]", "abc[[:digit:]]+"},
+			[]lexer.Option{lexer.WithFirstMatch()}, 1, 0},
+		{[]string{`if\b`, "[[:digit:]]+", `if\b`}, nil, 2, 0},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		err = l.Validate()
+		if serr, ok := err.(lexer.ShadowedPatternError); !ok {
+			t.Errorf("case %d, got error %v, want ShadowedPatternError",
+				n+1, err)
+		} else if serr.Index != tc.index || serr.By != tc.by {
+			t.Errorf("case %d, got pattern %d shadowed by %d, want %d by %d",
+				n+1, serr.Index, serr.By, tc.index, tc.by)
+		}
+	}
+}
+
+func TestValidateNotShadowed(t *testing.T) {
+	testCases := []struct {
+		lexemes []string
+		options []lexer.Option
+	}{
+		// The longest match wins, so longer operators are reachable
+		// after shorter ones, and longer identifiers after shorter
+		// ones, although keywords must precede identifiers.
+
+		{[]string{"=", "==", "if", "[[:alpha:]]+", "[[:digit:]]+"}, nil},
+		{[]string{"[[:alpha:]]+", "[[:alpha:]][[:alnum:]]+"}, nil},
+		{[]string{"==", "=", "if", "[[:alpha:]]+"},
+			[]lexer.Option{lexer.WithFirstMatch()}},
+		{[]string{"IF", "if"}, nil},
+
+		// Whether a pattern with an empty width assertion matches
+		// depends on what follows the lexeme, as in "if9" or "if if".
+
+		{[]string{`if\b`, "if"}, nil},
+		{[]string{"if$", "if"}, nil},
+		{[]string{`(?:\Aab|x)`, "ab"}, []lexer.Option{lexer.WithFirstMatch()}},
+	}
+
+	for n, tc := range testCases {
+		l, err := lexer.New(tc.lexemes, tc.options...)
+		if err != nil {
+			t.Fatalf("case %d, couldn't create lexer: %v", n+1, err)
+		}
+
+		if err := l.Validate(); err != nil {
+			t.Errorf("case %d, got error %v, want nil", n+1, err)
+		}
+	}
+}
+
+func TestValidateWithout(t *testing.T) {
+	l, err := lexer.New([]string{"if", "else", "[[:alpha:]]+", "if"})
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	without, err := l.Without(0, 1)
+	if err != nil {
+		t.Fatalf("couldn't remove patterns: %v", err)
+	}
+	err = without.Validate()
+	if serr, ok := err.(lexer.ShadowedPatternError); !ok ||
+		serr.Index != 3 || serr.By != 2 {
+		t.Errorf("got error %v, want pattern 3 shadowed by 2", err)
+	}
+
+	if without, err = l.Without(0, 1, 3); err != nil {
+		t.Fatalf("couldn't remove patterns: %v", err)
+	}
+	if err := without.Validate(); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
+
+func TestValidateModal(t *testing.T) {
+	l, err := lexer.NewModal(map[string][]string{
+		lexer.DefaultMode: {"[[:alpha:]]+"},
+		"expr":            {"[[:digit:]]+", "42"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("couldn't create lexer: %v", err)
+	}
+
+	err = l.Validate()
+	if serr, ok := err.(lexer.ShadowedPatternError); !ok {
+		t.Errorf("got error %v, want ShadowedPatternError", err)
+	} else if serr.Mode != "expr" || serr.Index != 1 || serr.By != 0 {
+		t.Errorf("got pattern %d in mode %q shadowed by %d, "+
+			"want 1 in mode \"expr\" by 0", serr.Index, serr.Mode, serr.By)
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	errs := lexer.Validate([]string{"[[:alpha:]]+", "(a", "b*", `\d+`, ""})
+	if len(errs) != 5 {
+		t.Fatalf("got %d errors, want 5", len(errs))
+	}
+
+	for _, i := range []int{0, 3} {
+		if errs[i] != nil {
+			t.Errorf("pattern %d, got error %v, want none", i, errs[i])
+		}
+	}
+	if perr, ok := errs[1].(lexer.PatternError); !ok {
+		t.Errorf("pattern 1, got error %v, want PatternError", errs[1])
+	} else if perr.Index != 1 || perr.Pattern != "(a" {
+		t.Errorf("pattern 1, got pattern %d %q, want 1 %q",
+			perr.Index, perr.Pattern, "(a")
+	}
+	for _, i := range []int{2, 4} {
+		if eerr, ok := errs[i].(lexer.EmptyMatchPatternError); !ok {
+			t.Errorf("pattern %d, got error %v, want EmptyMatchPatternError",
+				i, errs[i])
+		} else if eerr.Index != i {
+			t.Errorf("pattern %d, got index %d", i, eerr.Index)
+		}
+	}
+
+	if errs := lexer.Validate(nil); len(errs) != 0 {
+		t.Errorf("got %d errors for no patterns, want none", len(errs))
+	}
+}